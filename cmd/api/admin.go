@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+// readTargetUser loads the user that an administration route acts on. It writes an
+// error response and returns false when there is no such user.
+func (app *application) readTargetUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid user id"))
+		return nil, false
+	}
+
+	u, err := app.models.Users.GetAnyById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return u, true
+}
+
+// readModeratedUser loads the target of an action that changes their account. The
+// acting user must outrank the target, which also keeps them from acting on
+// themselves.
+func (app *application) readModeratedUser(w http.ResponseWriter, r *http.Request) (actor, target *data.User, ok bool) {
+	actor, ok = r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return nil, nil, false
+	}
+
+	target, ok = app.readTargetUser(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if !actor.Outranks(target) {
+		app.notPermittedResponse(w, r)
+		return nil, nil, false
+	}
+
+	return actor, target, true
+}
+
+// recordAdminAction keeps a record of an action taken on a user's account.
+func (app *application) recordAdminAction(actor, target *data.User, action, reason string) error {
+	err := app.models.AdminActions.Insert(&data.AdminAction{
+		ActorId: actor.Id,
+		UserId:  target.Id,
+		Action:  action,
+		Reason:  reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("Administrative action taken", map[string]string{
+		"action":   action,
+		"actor_id": strconv.Itoa(actor.Id),
+		"user_id":  strconv.Itoa(target.Id),
+		"reason":   reason,
+	})
+
+	return nil
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	queryStrings := r.URL.Query()
+	v := validator.New()
+
+	q := app.readString(queryStrings, "q", "")
+	role := app.readString(queryStrings, "role", "")
+	status := app.readString(queryStrings, "status", "")
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         app.readString(queryStrings, "sort", "id"),
+		SortSafelist: []string{"id", "created_at", "updated_at", "-id", "-created_at", "-updated_at"},
+	}
+
+	data.ValidateFilters(v, filters)
+	if role != "" {
+		data.ValidateRole(v, role)
+	}
+	v.Check(status == "" || validator.In(status, "active", "suspended", "deleted", "inactive"), "status", "must be one of active, suspended, deleted or inactive")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, meta, err := app.models.Users.GetAll(q, role, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "users": data.Accounts(users)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserAsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserSuspended(w, r, true)
+}
+
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserSuspended(w, r, false)
+}
+
+// setUserSuspended suspends or unsuspends a user. Suspending also logs the user out
+// everywhere, API keys are refused for as long as the suspension lasts.
+func (app *application) setUserSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actor, target, ok := app.readModeratedUser(w, r)
+	if !ok {
+		return
+	}
+
+	if target.IsSuspended() == suspended {
+		if suspended {
+			v.AddError("user", "is already suspended")
+		} else {
+			v.AddError("user", "is not suspended")
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.SetSuspended(target, suspended)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	action := data.ActionUnsuspend
+	if suspended {
+		action = data.ActionSuspend
+
+		err = app.models.Tokens.RevokeAllForUser(target.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.recordAdminAction(actor, target, action, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": target.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actor, target, ok := app.readModeratedUser(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Tokens.RevokeAllForUser(target.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAdminAction(actor, target, data.ActionLogout, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "the user was logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetActivationHandler marks a user's account as not activated and emails them a
+// new activation token, e.g. when their email address is in doubt.
+func (app *application) resetActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actor, target, ok := app.readModeratedUser(w, r)
+	if !ok {
+		return
+	}
+
+	target.Activated = false
+
+	err = app.models.Users.Update(target)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.OneTimeTokens.DeleteAllForUser(data.PurposeActivation, target.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	activationToken, err := app.models.OneTimeTokens.New(target.Id, 3*24*time.Hour, data.PurposeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAdminAction(actor, target, data.ActionResetActivation, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		mailData := map[string]any{
+			"name":            target.Name,
+			"activationToken": activationToken.Plaintext,
+		}
+
+		err := app.mailer.Send(target.Email, "user_activation.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(target.Id)})
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": target.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Role   string `json:"role"`
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateRole(v, input.Role)
+	data.ValidateReason(v, input.Reason)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actor, target, ok := app.readModeratedUser(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Users.SetRole(target, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAdminAction(actor, target, data.ActionSetRole, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": target.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserFriendsAsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	queryStrings := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         app.readString(queryStrings, "sort", "id"),
+		SortSafelist: []string{"id", "updated_at", "-id", "-updated_at"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	friends, meta, err := app.models.Friends.GetFriendsFor(u.Id, 0, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "friends": friends}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserFreeTimesAsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.readTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	queryStrings := r.URL.Query()
+
+	from := app.readDate(queryStrings, "from", "01-01-1970")
+	to := app.readDate(queryStrings, "to", "01-01-2100")
+
+	v := validator.New()
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         app.readString(queryStrings, "sort", "id"),
+		SortSafelist: []string{"id", "start_time", "end_time", "created_at", "-id", "-start_time", "-end_time", "-created_at"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	freeTimes, meta, err := app.models.FreeTimes.GetAllFor(u.Id, u.Id, filters, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "freetimes": freeTimes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getAdminActionsHandler lists the actions taken on accounts, those on one user
+// when the route has a user id.
+func (app *application) getAdminActionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := 0
+
+	if chi.URLParam(r, "id") != "" {
+		u, ok := app.readTargetUser(w, r)
+		if !ok {
+			return
+		}
+		userId = u.Id
+	}
+
+	v := validator.New()
+	queryStrings := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         app.readString(queryStrings, "sort", "-created_at"),
+		SortSafelist: []string{"id", "created_at", "-id", "-created_at"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actions, meta, err := app.models.AdminActions.GetAll(userId, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "actions": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
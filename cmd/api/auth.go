@@ -1,32 +1,29 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/AustinMusiku/Materix-go/internal/auth"
 	"github.com/AustinMusiku/Materix-go/internal/data"
 	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
 )
 
-type oauthUserInfo struct {
-	Email     string
-	firstName string
-	lastName  string
-	Provider  string
-	AvatarUrl string
-}
-
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Read request body into input struct
 	var input struct {
 		Email    string `json:"email"`
 		Name     string `json:"name"`
+		Handle   string `json:"handle"`
 		Password string `json:"password"`
+		Invite   string `json:"invite"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -39,6 +36,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	u := data.User{
 		Email:     input.Email,
 		Name:      input.Name,
+		Handle:    input.Handle,
 		Activated: false,
 		AvatarUrl: "",
 		Provider:  "email",
@@ -49,26 +47,91 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Users that don't choose a handle are given one from their name or email address
+	generateHandle := u.Handle == ""
+	base := ""
+	if generateHandle {
+		base = handleBase(u.Name, strings.Split(u.Email, "@")[0])
+		u.Handle = generatedHandle(base, 0)
+	}
+
 	// Validate user details
 	v := validator.New()
-	if data.ValidateUser(v, &u); !v.Valid() {
+	data.ValidateUser(v, &u)
+	input.Invite = strings.ToLower(input.Invite)
+	if input.Invite != "" {
+		data.ValidateInviteCode(v, input.Invite)
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the invite up front so that a bad one is reported rather than skipped
+	if input.Invite != "" {
+		_, err = app.models.FriendInvites.GetUsable(input.Invite)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("invite", "invite not found or no longer valid")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
 	}
 
-	// Save user in database
-	err = app.models.Users.Insert(&u)
+	// Save the user, their email identity and the invite's friendship together
+	var invite *data.FriendInvite
+	register := func(u *data.User) error {
+		invite, err = app.models.Users.Register(u, data.NewEmailIdentity, input.Invite)
+		return err
+	}
+
+	if generateHandle {
+		err = app.insertWithGeneratedHandle(&u, base, register)
+	} else {
+		err = register(&u)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateHandle):
+			v.AddError("handle", "this handle is already taken")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	tokens, err := data.NewTokenPair(u)
+	// The invite may have been used up since it was checked; the signup still stands
+	if invite != nil {
+		app.forgetSuggestions(invite.UserId, u.Id)
+	}
+
+	activationToken, err := app.models.OneTimeTokens.New(u.Id, 3*24*time.Hour, data.PurposeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		mailData := map[string]any{
+			"name":            u.Name,
+			"activationToken": activationToken.Plaintext,
+		}
+
+		err := app.mailer.Send(u.Email, "user_welcome.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		}
+	})
+
+	tokens, err := app.newSession(r, &u, data.ProviderEmail, data.DefaultScopes())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -84,6 +147,7 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Scope    string `json:"scope"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -95,15 +159,26 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 	v := validator.New()
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
+	scopes := requestedScopes(v, input.Scope)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	retryAfter, err := app.checkLogin(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
 	u, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, input.Email)
 			return
 		}
 		app.serverErrorResponse(w, r, err)
@@ -112,14 +187,159 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 
 	if ok, err := u.Password.Compare(input.Password); !ok {
 		if err == nil {
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, input.Email)
 		} else {
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	tokens, err := data.NewTokenPair(*u)
+	err = app.succeedLogin(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.loginResponse(w, r, u, data.ProviderEmail, scopes)
+}
+
+// failedLoginResponse counts a failed password login before rejecting it. Unknown
+// accounts are counted too so that they can't be told apart by the lockout.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string) {
+	err := app.failLogin(r, email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// confirmPassword checks the current password a logged in user gave to confirm a
+// sensitive change. Wrong passwords count towards the lockout of the account, so
+// that a stolen access token can't be used to guess it.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, u *data.User, password string) bool {
+	retryAfter, err := app.checkLogin(r, u.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return false
+	}
+
+	ok, err := u.Password.Compare(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		app.failedLoginResponse(w, r, u.Email)
+		return false
+	}
+
+	err = app.succeedLogin(u.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.RefreshToken != "", "refresh_token", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := data.ParseRefreshToken(app.keys, input.RefreshToken)
+	if err != nil {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	ok, err := claims.Verify()
+	if err != nil || !ok {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	rt, err := app.models.RefreshTokens.Get(claims.Jti)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if rt.Revoked {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	// A refresh token can only be exchanged once. Seeing it again means that it
+	// has leaked, so the whole family is revoked to log out both the legitimate
+	// client and whoever replayed the token.
+	if rt.Used {
+		app.revokeReusedTokenFamily(w, r, rt)
+		return
+	}
+
+	err = app.models.RefreshTokens.MarkUsed(rt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.revokeReusedTokenFamily(w, r, rt)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	u, err := app.models.Users.GetAnyById(rt.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if u.IsDeleted() {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	if u.IsSuspended() {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	err = app.models.Sessions.Touch(rt.FamilyId, r.UserAgent(), clientIP(r))
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	tokens, err := app.newTokenPair(u, rt.FamilyId, claims.Scopes())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -131,90 +351,235 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 	}
 }
 
-func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	var clientId string
-	var clientSecret string
-	var baseAccessTokenUrl string
-	var oauthProvider string
+func (app *application) revokeReusedTokenFamily(w http.ResponseWriter, r *http.Request, rt *data.RefreshToken) {
+	app.logger.Warn("Refresh token reuse detected, revoking token family", map[string]string{
+		"family_id": rt.FamilyId,
+		"user_id":   strconv.Itoa(rt.UserId),
+	})
 
-	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state")
-	redirect_uri := "https://materix.up.railway.app/api/v1/auth/callback"
+	err := app.models.Tokens.RevokeFamily(rt.FamilyId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	// Verify state
-	if state != os.Getenv("OAUTH2_CALLBACK_STATE") {
-		app.badRequestResponse(w, r, errors.New("invalid state"))
+	app.invalidRefreshTokenResponse(w, r)
+}
+
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
 		return
 	}
 
-	// Assign provider value to oauthProvider after examining the request URL
-	if strings.Contains(r.URL.String(), "google") {
-		clientId = os.Getenv("GOOGLE_CLIENT_ID")
-		clientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-		baseAccessTokenUrl = "https://oauth2.googleapis.com/token"
-		oauthProvider = "google"
-	} else {
-		clientId = os.Getenv("GITHUB_CLIENT_ID")
-		clientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-		baseAccessTokenUrl = "https://github.com/login/oauth/access_token"
-		oauthProvider = "github"
+	claims, ok := r.Context().Value(claimsContextKey).(*data.UserClaims)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing claims value"))
+		return
 	}
 
-	// Exchange code for access token
-	authTokenUrl := fmt.Sprintf("%s?client_id=%s&client_secret=%s&code=%s&redirect_uri=%s&grant_type=authorization_code", baseAccessTokenUrl, clientId, clientSecret, code, redirect_uri)
-	req, err := http.NewRequest("POST", authTokenUrl, nil)
+	err := app.models.Tokens.RevokeToken(u.Id, claims.Jti, claims.Exp.Time)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	if claims.Family != "" {
+		err = app.models.Tokens.RevokeFamily(claims.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
-	res, err := client.Do(req)
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Logged out"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
 		return
 	}
-	defer res.Body.Close()
 
-	// Read response body
-	token := struct {
-		Access_token string `json:"access_token"`
-	}{}
-	err = json.NewDecoder(res.Body).Decode(&token)
+	err := app.models.Tokens.RevokeAllForUser(u.Id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Extract user info from provider api
-	userInfo, err := extractOauthUser(token.Access_token, oauthProvider)
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Respond the same way whether or not the email address belongs to a user so
+	// that this endpoint can't be used to find out who has an account.
+	message := "an email will be sent to you containing password reset instructions"
+
+	u, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.writeJSON(w, http.StatusAccepted, ResponseWrapper{"message": message}, nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if u.IsDeleted() {
+		app.writeJSON(w, http.StatusAccepted, ResponseWrapper{"message": message}, nil)
+		return
+	}
+
+	resetToken, err := app.models.OneTimeTokens.New(u.Id, 45*time.Minute, data.PurposePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		mailData := map[string]any{
+			"name":               u.Name,
+			"passwordResetToken": resetToken.Plaintext,
+		}
+
+		err := app.mailer.Send(u.Email, "password_reset.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, ResponseWrapper{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetForToken(data.PurposePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = u.Password.Set(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Check if user exists
-	u, err := app.models.Users.GetByEmail(userInfo.Email)
-	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
-		// Create a new user
-		u = &data.User{
-			Email:     userInfo.Email,
-			Name:      userInfo.firstName + " " + userInfo.lastName,
-			Activated: true,
-			AvatarUrl: userInfo.AvatarUrl,
-			Provider:  oauthProvider,
+	err = app.models.Users.Update(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	err = app.models.OneTimeTokens.DeleteAllForUser(data.PurposePasswordReset, u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Users who signed up with an oauth provider gain an email login by setting a
+	// password
+	err = app.models.Identities.Insert(data.NewEmailIdentity(u))
+	if err != nil && !errors.Is(err, data.ErrDuplicateIdentity) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.RevokeAllForUser(u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauth.Get(chi.URLParam(r, "provider"))
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("oauth provider not found"))
+		return
+	}
+
+	v := validator.New()
+	scopes := requestedScopes(v, r.URL.Query().Get("scope"))
+	invite := strings.ToLower(r.URL.Query().Get("invite"))
+	if invite != "" {
+		data.ValidateInviteCode(v, invite)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-		// Save user in database
-		err = app.models.Users.Insert(u)
+	// The invite is carried through the flow and redeemed if the login signs up a
+	// new user
+	if invite != "" {
+		_, err := app.models.FriendInvites.GetUsable(invite)
 		if err != nil {
 			switch {
-			case errors.Is(err, data.ErrDuplicateEmail):
-				v := validator.New()
-				v.AddError("email", "a user with this email address already exists")
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("invite", "invite not found or no longer valid")
 				app.failedValidationResponse(w, r, v.Errors)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -223,114 +588,239 @@ func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	tokens, err := data.NewTokenPair(*u)
+	authReq, err := app.oauthFlow.Begin(w, provider.Name(), data.FormatScope(scopes), invite)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"tokens": tokens}, nil)
+	http.Redirect(w, r, provider.AuthCodeURL(authReq), http.StatusFound)
+}
+
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauth.Get(chi.URLParam(r, "provider"))
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("oauth provider not found"))
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// The provider redirects back with an error when the user denies access
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("oauth login failed: %s", qs.Get("error")))
+		return
+	}
+
+	// Verify that the login was started by this browser and hasn't completed yet
+	authReq, err := app.oauthFlow.Complete(w, r, provider.Name())
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, errors.New("invalid or expired oauth state"))
+		return
 	}
-}
 
-func extractOauthUser(accessToken string, provider string) (oauthUserInfo, error) {
-	var userInfo oauthUserInfo
+	code := qs.Get("code")
+	if code == "" {
+		app.badRequestResponse(w, r, errors.New("missing authorization code"))
+		return
+	}
 
-	userEndpoint := "https://api.github.com/user"
-	if provider == "google" {
-		userEndpoint = "https://www.googleapis.com/oauth2/v3/userinfo"
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	// Exchange code for access token
+	token, err := provider.Exchange(ctx, code, authReq)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrProviderRejected):
+			app.badRequestResponse(w, r, errors.New("the authorization code was rejected by the provider"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	// Request user profile info
-	req, err := http.NewRequest("GET", userEndpoint, nil)
+	// Extract user info from provider api
+	identity, err := provider.Identity(ctx, token)
 	if err != nil {
-		return userInfo, err
+		switch {
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			v := validator.New()
+			v.AddError("email", fmt.Sprintf("your email address must be verified with %s before you can log in", provider.Name()))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	client := &http.Client{
-		Timeout: time.Second * 5,
+	if authReq.LinkUserId != 0 {
+		app.linkOAuthIdentity(w, r, authReq.LinkUserId, identity)
+		return
 	}
 
-	res, err := client.Do(req)
+	u, err := app.userForOAuthIdentity(identity, authReq.Invite)
 	if err != nil {
-		return userInfo, err
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v := validator.New()
+			v.AddError("email", fmt.Sprintf("a user with this email address already exists, log in and link your %s account instead", identity.Provider))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.loginResponse(w, r, u, provider.Name(), data.ParseScope(authReq.Scope))
+}
+
+// userForOAuthIdentity resolves the user that logged in with an OAuth identity by
+// the provider's subject, creating a new user for identities seen for the first
+// time. Accounts are never matched by email alone, since that would let anyone
+// controlling the address at some provider take over the account. New accounts
+// whose address the provider hasn't verified must be activated by email, and are
+// made friends with the creator of the invite, if one was given.
+func (app *application) userForOAuthIdentity(identity *auth.Identity, inviteCode string) (*data.User, error) {
+	linked, err := app.models.Identities.GetByProviderSubject(identity.Provider, identity.Subject)
+	if err == nil {
+		return app.models.Users.GetAnyById(linked.UserId)
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Identities migrated from the users table don't know their subject yet
+	if identity.EmailVerified {
+		linked, err = app.models.Identities.ClaimLegacy(identity.Provider, identity.Subject, identity.Email)
+		if err == nil {
+			return app.models.Users.GetAnyById(linked.UserId)
+		}
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	// Only an address the provider verified counts as activating the account
+	u := &data.User{
+		Email:     identity.Email,
+		Name:      identity.Name,
+		Activated: identity.EmailVerified,
+		AvatarUrl: identity.AvatarUrl,
+		Provider:  identity.Provider,
+	}
+
+	newIdentity := func(u *data.User) *data.Identity {
+		return &data.Identity{
+			UserId:   u.Id,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}
 	}
-	defer res.Body.Close()
 
-	// Parse api response
-	var body struct {
-		Name        string `json:"name"`
-		Email       string `json:"email"`
-		AvatarUrl   string `json:"avatar_url"`
-		Picture     string `json:"picture"`
-		Given_name  string `json:"given_name"`
-		Family_name string `json:"family_name"`
+	var invite *data.FriendInvite
+	register := func(u *data.User) error {
+		invite, err = app.models.Users.Register(u, newIdentity, inviteCode)
+		return err
 	}
-	err = json.NewDecoder(res.Body).Decode(&body)
+
+	// New users are given a handle from their username at the provider, their name
+	// or their email address
+	err = app.insertWithGeneratedHandle(u, handleBase(identity.Username, identity.Name, strings.Split(identity.Email, "@")[0]), register)
 	if err != nil {
-		return userInfo, err
+		return nil, err
+	}
+
+	if invite != nil {
+		app.forgetSuggestions(invite.UserId, u.Id)
 	}
 
-	if provider == "github" {
-		if body.Email == "" {
-			email, err := fetchGithubUserEmail(accessToken)
+	if !u.Activated {
+		activationToken, err := app.models.OneTimeTokens.New(u.Id, 3*24*time.Hour, data.PurposeActivation)
+		if err != nil {
+			return nil, err
+		}
+
+		app.background(func() {
+			mailData := map[string]any{
+				"name":            u.Name,
+				"activationToken": activationToken.Plaintext,
+			}
+
+			err := app.mailer.Send(u.Email, "user_welcome.tmpl", mailData)
 			if err != nil {
-				return userInfo, err
+				app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
 			}
-			body.Email = email
-		}
-		names := strings.Split(body.Name, " ")
-		userInfo.firstName = names[0]
-		userInfo.lastName = strings.Join(names[1:], " ")
-		userInfo.AvatarUrl = body.AvatarUrl
-		userInfo.Email = body.Email
-	} else if provider == "google" {
-		userInfo.Email = body.Email
-		userInfo.firstName = body.Given_name
-		userInfo.lastName = body.Family_name
-		userInfo.AvatarUrl = body.Picture
-	}
-	userInfo.Provider = provider
-
-	return userInfo, nil
+		})
+	}
+
+	return u, nil
 }
 
-func fetchGithubUserEmail(token string) (string, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+// insertWithGeneratedHandle inserts a user that didn't choose a handle with the
+// insert function, giving them one made from base, see generatedHandle.
+func (app *application) insertWithGeneratedHandle(u *data.User, base string, insert func(*data.User) error) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		u.Handle = generatedHandle(base, attempt)
 
-	client := &http.Client{
-		Timeout: time.Second * 5,
+		err := insert(u)
+		if !errors.Is(err, data.ErrDuplicateHandle) {
+			return err
+		}
 	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return "", errors.New("failed to fetch user email")
+	return data.ErrDuplicateHandle
+}
+
+// handleBase returns the handle made from the first of the names that makes one,
+// or an empty string when none does.
+func handleBase(names ...string) string {
+	for _, name := range names {
+		if base := data.HandleFromName(name); base != "" {
+			return base
+		}
 	}
-	defer res.Body.Close()
+	return ""
+}
 
-	var emails []struct {
-		Email    string `json:"email"`
-		Primary  bool   `json:"primary"`
-		Verified bool   `json:"verified"`
+// generatedHandle returns the handle to try on the given attempt at inserting a
+// user with a handle made from base. Taken handles are retried with a random
+// number appended.
+func generatedHandle(base string, attempt int) string {
+	switch {
+	case base != "" && attempt == 0:
+		return base
+	case base != "":
+		return fmt.Sprintf("%.25s%d", base, rand.Intn(10000))
+	default:
+		return fmt.Sprintf("user%d", rand.Intn(1000000))
 	}
+}
 
-	err = json.NewDecoder(res.Body).Decode(&emails)
-	if err != nil {
-		return "", errors.New("failed to fetch user email")
+func (app *application) linkOAuthIdentity(w http.ResponseWriter, r *http.Request, userId int, identity *auth.Identity) {
+	linked := &data.Identity{
+		UserId:   userId,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
 	}
 
-	for _, email := range emails {
-		if email.Primary && email.Verified {
-			return email.Email, nil
+	err := app.models.Identities.Insert(linked)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateIdentity):
+			v := validator.New()
+			v.AddError("provider", fmt.Sprintf("this %s account is already linked to a user, or you already linked another one", identity.Provider))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
 	}
 
-	return "", errors.New("failed to fetch user email")
+	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"identity": linked}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
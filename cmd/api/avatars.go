@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/blob"
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/imaging"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+// avatarSizes are the widths, in pixels, of the square variants an uploaded
+// avatar is stored in, smallest first. The user's avatar url points at
+// avatarDefaultSize.
+var avatarSizes = []int{64, 128, 256, 512}
+
+const (
+	avatarDefaultSize = 256
+	avatarMaxPixels   = 16_000_000
+	avatarQuality     = 85
+)
+
+// avatarContentTypes are the content types an avatar may be uploaded as.
+var avatarContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// uploadAvatarHandler replaces the user's avatar with an uploaded image, cropped
+// to a square and stored in every size of avatarSizes.
+func (app *application) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	maxBytes := app.config.avatars.maxBytes
+
+	// Leave room for the multipart boundaries and headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+
+	v := validator.New()
+
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError), err.Error() == "http: request body too large":
+			v.AddError("avatar", fmt.Sprintf("must not be larger than %d bytes", maxBytes))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		v.AddError("avatar", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	upload, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(upload) > 0, "avatar", "must not be empty")
+	v.Check(int64(len(upload)) <= maxBytes, "avatar", fmt.Sprintf("must not be larger than %d bytes", maxBytes))
+	v.Check(validator.In(header.Header.Get("Content-Type"), avatarContentTypes...), "avatar", "must be a JPEG, PNG or GIF image")
+	v.Check(validator.In(http.DetectContentType(upload), avatarContentTypes...), "avatar", "must be a JPEG, PNG or GIF image")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := imaging.Decode(upload, avatarMaxPixels)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrTooManyPixels):
+			v.AddError("avatar", "must not be larger than 16 megapixels")
+		default:
+			v.AddError("avatar", "must be a valid JPEG, PNG or GIF image")
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetById(claims.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	dir, err := app.storeAvatar(r.Context(), u, imaging.CropSquare(img))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous := u.AvatarUrl
+	u.AvatarUrl = app.avatarURL(dir, avatarDefaultSize)
+
+	err = app.models.Users.Update(u)
+	if err != nil {
+		app.deleteAvatar(dir)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if previousDir, ok := app.avatarDir(u, previous); ok {
+		app.background(func() {
+			app.deleteAvatar(previousDir)
+		})
+	}
+
+	variants := make(map[string]string, len(avatarSizes))
+	for _, size := range avatarSizes {
+		variants[strconv.Itoa(size)] = app.avatarURL(dir, size)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u.Account(), "avatar_variants": variants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// storeAvatar resizes a square image to every avatar size and stores the
+// variants in a new directory, which is returned. Every upload gets a directory
+// of its own, so a variant never changes once it is served.
+func (app *application) storeAvatar(ctx context.Context, u *data.User, img image.Image) (string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+
+	dir := fmt.Sprintf("avatars/%s/%s", u.Uuid, id)
+
+	// Each variant is scaled down from the next larger one rather than from the
+	// full image, which only has to be read once
+	for i := len(avatarSizes) - 1; i >= 0; i-- {
+		size := avatarSizes[i]
+
+		resized := imaging.Resize(img, size, size)
+		img = resized
+
+		variant, err := imaging.EncodeJPEG(resized, avatarQuality)
+		if err != nil {
+			return "", err
+		}
+
+		err = app.avatars.Put(ctx, fmt.Sprintf("%s/%d.jpg", dir, size), variant, "image/jpeg")
+		if err != nil {
+			app.deleteAvatar(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// serveAvatarHandler serves the stored avatar variants. They never change, but
+// are only cached for a day so that the avatars of users that are suspended or
+// deleted stop being served soon after.
+func (app *application) serveAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	key := "avatars/" + chi.URLParam(r, "*")
+
+	// Avatars are stored under the uuid of the user that uploaded them
+	uuid, _, _ := strings.Cut(chi.URLParam(r, "*"), "/")
+
+	active, err := app.models.Users.IsActive(uuid)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !active {
+		app.notFoundResponse(w, r, errors.New("avatar not found"))
+		return
+	}
+
+	obj, err := app.avatars.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound), errors.Is(err, blob.ErrInvalidKey):
+			app.notFoundResponse(w, r, errors.New("avatar not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	http.ServeContent(w, r, key, obj.ModTime, bytes.NewReader(obj.Data))
+}
+
+// avatarURL returns the public url of the variant of the given size of the
+// avatar stored in dir.
+func (app *application) avatarURL(dir string, size int) string {
+	return fmt.Sprintf("%s/api/%s/%d.jpg", app.config.baseURL, dir, size)
+}
+
+// avatarDir returns the directory of the user's avatar url when it points at an
+// avatar they uploaded. Avatars supplied by OAuth providers aren't stored, and
+// users may set their avatar url to anything, so ok is false for any other url.
+// Only the path is compared, so that avatars stored under an earlier base url
+// are still recognised.
+func (app *application) avatarDir(u *data.User, avatarURL string) (dir string, ok bool) {
+	parsed, err := url.Parse(avatarURL)
+	if err != nil {
+		return "", false
+	}
+
+	key, ok := strings.CutPrefix(parsed.Path, "/api/")
+	if !ok || !strings.HasPrefix(key, "avatars/"+u.Uuid+"/") || !blob.ValidKey(key) {
+		return "", false
+	}
+
+	return key[:strings.LastIndex(key, "/")], true
+}
+
+// deleteAvatar deletes every variant of the avatar stored in dir.
+func (app *application) deleteAvatar(dir string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, size := range avatarSizes {
+		key := fmt.Sprintf("%s/%d.jpg", dir, size)
+
+		err := app.avatars.Delete(ctx, key)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"key": key})
+		}
+	}
+}
+
+// deleteUserAvatars deletes every avatar the user with the uuid uploaded.
+func (app *application) deleteUserAvatars(uuid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := app.avatars.DeletePrefix(ctx, "avatars/"+uuid)
+	if err != nil {
+		app.logger.Error(err, map[string]string{"uuid": uuid})
+	}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+// blockUserHandler blocks a user. Blocked users can't send friend requests to
+// the blocker or find them, and any friendship between the two is ended.
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	blockedId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid user id"))
+		return
+	}
+
+	v := validator.New()
+	if v.Check(blockedId != u.Id, "id", "you can't block yourself"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	blocked, err := app.models.Users.GetAnyById(blockedId)
+	if err != nil || blocked.IsDeleted() {
+		switch {
+		case err == nil, errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Blocks.Insert(u.Id, blocked.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.forgetSuggestions(u.Id, blocked.Id)
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "User blocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	blockedId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid user id"))
+		return
+	}
+
+	err = app.models.Blocks.Delete(u.Id, blockedId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("block not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.forgetSuggestions(u.Id, blockedId)
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "User unblocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getMyBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	queryStrings := r.URL.Query()
+	v := validator.New()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	blocked, meta, err := app.models.Blocks.GetAllFor(u.Id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "blocked": blocked}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/archive"
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+// exportTTL is how long a data export can be downloaded for.
+const exportTTL = 7 * 24 * time.Hour
+
+// createDataExportHandler starts assembling an archive of the user's data in the
+// background. The archive is downloaded with a link that is returned here and
+// emailed once the archive is ready.
+func (app *application) createDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	current, err := app.models.DataExports.GetLatestForUser(u.Id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if current != nil && current.Status == data.ExportPending {
+		v := validator.New()
+		v.AddError("export", "your previous export is still being prepared")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	export, err := app.models.DataExports.New(u.Id, exportTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.OneTimeTokens.DeleteAllForUser(data.PurposeDataExport, u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	downloadToken, err := app.models.OneTimeTokens.New(u.Id, exportTTL, data.PurposeDataExport)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	downloadURL := "/api/exports/download?token=" + url.QueryEscape(downloadToken.Plaintext)
+
+	app.background(func() {
+		app.buildDataExport(u, export, downloadURL)
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, ResponseWrapper{"export": export, "download_url": downloadURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildDataExport assembles and stores the archive of an export, then emails the
+// user the link to download it.
+func (app *application) buildDataExport(u *data.User, export *data.DataExport, downloadURL string) {
+	buf := new(bytes.Buffer)
+
+	pd, err := app.models.DataExports.Collect(u.Id)
+	if err == nil {
+		err = archive.Write(buf, "materix", pd, personalDataTables(pd))
+	}
+
+	if err != nil {
+		app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+
+		err = app.models.DataExports.Complete(export, nil)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		}
+		return
+	}
+
+	err = app.models.DataExports.Complete(export, buf.Bytes())
+	if err != nil {
+		app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		return
+	}
+
+	mailData := map[string]any{
+		"name":        u.Name,
+		"downloadURL": app.config.baseURL + downloadURL,
+		"expiry":      export.Expiry.UTC().Format("Jan 2, 2006"),
+	}
+
+	err = app.mailer.Send(u.Email, "data_export.tmpl", mailData)
+	if err != nil {
+		app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+	}
+}
+
+func (app *application) getDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	export, err := app.models.DataExports.GetLatestForUser(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("data export not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadDataExportHandler serves an export's archive to whoever holds its
+// download link, until the export expires.
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetForToken(data.PurposeDataExport, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("invalid or expired download link"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	export, err := app.models.DataExports.GetArchiveForUser(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("the data export is not ready yet"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("materix-export-%s.zip", export.CreatedAt.UTC().Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(export.Archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// personalDataTables lays out personal data as the tables of the CSV files in an
+// export.
+func personalDataTables(pd *data.PersonalData) []archive.Table {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	profile := archive.Table{
+		Name:   "profile",
+		Header: []string{"id", "uuid", "name", "handle", "email", "avatar_url", "provider", "activated", "role", "created_at", "updated_at", "deleted_at"},
+		Rows: [][]string{{
+			strconv.Itoa(pd.Profile.Id),
+			pd.Profile.Uuid,
+			pd.Profile.Name,
+			pd.Profile.Handle,
+			pd.Profile.Email,
+			pd.Profile.AvatarUrl,
+			pd.Profile.Provider,
+			strconv.FormatBool(pd.Profile.Activated),
+			pd.Profile.Role,
+			pd.Profile.CreatedAt,
+			pd.Profile.UpdatedAt,
+			formatTime(pd.Profile.DeletedAt),
+		}},
+	}
+
+	identities := archive.Table{
+		Name:   "identities",
+		Header: []string{"id", "provider", "email", "linked_at"},
+	}
+	for _, identity := range pd.Identities {
+		identities.Rows = append(identities.Rows, []string{
+			strconv.Itoa(identity.Id),
+			identity.Provider,
+			identity.Email,
+			identity.LinkedAt,
+		})
+	}
+
+	friendsTable := func(name string, friends []*data.ExportedFriend) archive.Table {
+		table := archive.Table{
+			Name:   name,
+			Header: []string{"id", "user_id", "user_name", "direction", "status", "created_at", "updated_at"},
+		}
+		for _, friend := range friends {
+			table.Rows = append(table.Rows, []string{
+				strconv.Itoa(friend.Id),
+				strconv.Itoa(friend.UserId),
+				friend.UserName,
+				friend.Direction,
+				friend.Status,
+				friend.CreatedAt,
+				friend.UpdatedAt,
+			})
+		}
+		return table
+	}
+
+	freeTimes := archive.Table{
+		Name:   "free_times",
+		Header: []string{"id", "start_time", "end_time", "tags", "visibility", "created_at", "updated_at"},
+	}
+	for _, ft := range pd.FreeTimes {
+		freeTimes.Rows = append(freeTimes.Rows, []string{
+			strconv.Itoa(ft.Id),
+			ft.StartTime.Format(time.RFC3339),
+			ft.EndTime.Format(time.RFC3339),
+			strings.Join(ft.Tags, " "),
+			ft.Visibility,
+			ft.CreatedAt.Format(time.RFC3339),
+			ft.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+
+	viewers := archive.Table{
+		Name:   "free_time_viewers",
+		Header: []string{"free_time_id", "user_id", "user_name"},
+	}
+	for _, viewer := range pd.FreeTimeViewers {
+		viewers.Rows = append(viewers.Rows, []string{
+			strconv.Itoa(viewer.FreeTimeId),
+			strconv.Itoa(viewer.UserId),
+			viewer.UserName,
+		})
+	}
+
+	return []archive.Table{
+		profile,
+		identities,
+		friendsTable("friends", pd.Friends),
+		friendsTable("friend_requests", pd.FriendRequests),
+		freeTimes,
+		viewers,
+	}
+}
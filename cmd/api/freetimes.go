@@ -23,7 +23,8 @@ func (app *application) addFreeTimeHandler(w http.ResponseWriter, r *http.Reques
 		EndTime    time.Time `json:"end_time"`
 		Tags       []string  `json:"tags"`
 		Visibility string    `json:"visibility"`
-		Viewers    []int     `json:"viewers"`
+		Viewers    []int64   `json:"viewers"`
+		Groups     []int64   `json:"groups"`
 	}{}
 
 	err := app.readJSON(w, r, &input)
@@ -38,6 +39,12 @@ func (app *application) addFreeTimeHandler(w http.ResponseWriter, r *http.Reques
 		EndTime:    input.EndTime,
 		Tags:       input.Tags,
 		Visibility: input.Visibility,
+		Viewers:    input.Viewers,
+		Groups:     input.Groups,
+	}
+
+	if ft.Visibility == "" {
+		ft.Visibility = data.FreeTimeFriends
 	}
 
 	v := validator.New()
@@ -46,9 +53,9 @@ func (app *application) addFreeTimeHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	insertedFreetime, err := app.models.FreeTimes.Insert(&ft, input.Viewers)
+	insertedFreetime, err := app.models.FreeTimes.Insert(&ft)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.freeTimeSharingErrorResponse(w, r, v, err)
 		return
 	}
 
@@ -89,7 +96,7 @@ func (app *application) getMyFreeTimesHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	freeTimes, meta, err := app.models.FreeTimes.GetAllFor(u.Id, input.Filters, input.From, input.To)
+	freeTimes, meta, err := app.models.FreeTimes.GetAllFor(u.Id, u.Id, input.Filters, input.From, input.To)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -121,9 +128,12 @@ func (app *application) updateFreeTimeHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	var input struct {
-		StartTime *time.Time `json:"start_time"`
-		EndTime   *time.Time `json:"end_time"`
-		Tags      *[]string  `json:"tags"`
+		StartTime  *time.Time `json:"start_time"`
+		EndTime    *time.Time `json:"end_time"`
+		Tags       *[]string  `json:"tags"`
+		Visibility *string    `json:"visibility"`
+		Viewers    *[]int64   `json:"viewers"`
+		Groups     *[]int64   `json:"groups"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -160,6 +170,26 @@ func (app *application) updateFreeTimeHandler(w http.ResponseWriter, r *http.Req
 		ft.Tags = *input.Tags
 	}
 
+	if input.Visibility != nil {
+		ft.Visibility = *input.Visibility
+
+		// Switching to another visibility drops the viewers or groups of the old one
+		if ft.Visibility != data.FreeTimeSpecific {
+			ft.Viewers = nil
+		}
+		if ft.Visibility != data.FreeTimeGroups {
+			ft.Groups = nil
+		}
+	}
+
+	if input.Viewers != nil {
+		ft.Viewers = *input.Viewers
+	}
+
+	if input.Groups != nil {
+		ft.Groups = *input.Groups
+	}
+
 	v := validator.New()
 	if valid := data.ValidateFreeTime(v, ft); !valid {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -168,7 +198,12 @@ func (app *application) updateFreeTimeHandler(w http.ResponseWriter, r *http.Req
 
 	updatedFreetime, err := app.models.FreeTimes.Update(ft)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.freeTimeSharingErrorResponse(w, r, v, err)
+		}
 		return
 	}
 
@@ -251,12 +286,17 @@ func (app *application) getMyFriendsFreeTimesHandler(w http.ResponseWriter, r *h
 		SortSafelist: []string{"id", "start_time", "end_time", "created_at", "-id", "-start_time", "-end_time", "-created_at"},
 	}
 
+	groupId, ok := app.readGroupFilter(w, r, u, queryStrings, v)
+	if !ok {
+		return
+	}
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	freeTimes, meta, err := app.models.FreeTimes.GetAllForFriendsOf(u.Id, input.Filters, input.From, input.To)
+	freeTimes, meta, err := app.models.FreeTimes.GetAllForFriendsOf(u.Id, groupId, input.Filters, input.From, input.To)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -287,7 +327,8 @@ func (app *application) getFriendFreeTimesHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	_, err = app.models.Friends.GetFriend(u.Id, friendId)
+	// Anyone may look at public free times, the rest depend on the friendship
+	_, err = app.models.Users.GetById(friendId)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -298,6 +339,17 @@ func (app *application) getFriendFreeTimesHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	blocked, err := app.models.Blocks.IsBlocked(u.Id, friendId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if blocked {
+		app.notFoundResponse(w, r, errors.New("friend not found"))
+		return
+	}
+
 	input := struct {
 		data.Filters
 		From time.Time
@@ -321,7 +373,7 @@ func (app *application) getFriendFreeTimesHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	freeTimes, meta, err := app.models.FreeTimes.GetAllFor(friendId, data.Filters{}, time.Time{}, time.Time{})
+	freeTimes, meta, err := app.models.FreeTimes.GetAllFor(friendId, u.Id, input.Filters, input.From, input.To)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -332,3 +384,18 @@ func (app *application) getFriendFreeTimesHandler(w http.ResponseWriter, r *http
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// freeTimeSharingErrorResponse reports an error saving a free time, which may be
+// caused by sharing it with users that aren't friends or groups that don't exist.
+func (app *application) freeTimeSharingErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrNotFriends):
+		v.AddError("viewers", "must all be your friends")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrUnknownFriendGroup):
+		v.AddError("groups", "must all be your friend groups")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
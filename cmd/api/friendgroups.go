@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+// readFriendGroup returns the friend group in the id URL parameter, sending an
+// error response and returning false when the user doesn't own such a group.
+func (app *application) readFriendGroup(w http.ResponseWriter, r *http.Request, u *data.User) (*data.FriendGroup, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid friend group id"))
+		return nil, false
+	}
+
+	group, err := app.models.FriendGroups.Get(id, u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("friend group not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return group, true
+}
+
+// readGroupFilter reads the group query parameter that narrows friend listings
+// down to the members of one of the user's groups. It returns 0 when absent,
+// and sends an error response and returns false when the group doesn't exist.
+func (app *application) readGroupFilter(w http.ResponseWriter, r *http.Request, u *data.User, qs url.Values, v *validator.Validator) (int, bool) {
+	groupId := app.readInt(qs, "group", 0, v)
+	if groupId == 0 {
+		return 0, true
+	}
+
+	_, err := app.models.FriendGroups.Get(groupId, u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("friend group not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+
+	return groupId, true
+}
+
+func (app *application) getMyFriendGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	queryStrings := r.URL.Query()
+	v := validator.New()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	groups, meta, err := app.models.FriendGroups.GetAllFor(u.Id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "groups": groups}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getFriendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	group, ok := app.readFriendGroup(w, r, u)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, ResponseWrapper{"group": group}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createFriendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	group := &data.FriendGroup{
+		UserId: u.Id,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+	if data.ValidateFriendGroup(v, group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.FriendGroups.Insert(group)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateFriendGroupName):
+			v.AddError("name", "a friend group with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"group": group}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateFriendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	group, ok := app.readFriendGroup(w, r, u)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		group.Name = *input.Name
+	}
+
+	v := validator.New()
+	if data.ValidateFriendGroup(v, group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.FriendGroups.Update(group)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateFriendGroupName):
+			v.AddError("name", "a friend group with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"group": group}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteFriendGroupHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	group, ok := app.readFriendGroup(w, r, u)
+	if !ok {
+		return
+	}
+
+	err := app.models.FriendGroups.Delete(group)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend group deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addFriendGroupMemberHandler adds one of the user's friends to their group.
+func (app *application) addFriendGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	group, ok := app.readFriendGroup(w, r, u)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Id int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Id > 0, "id", "must be valid"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.FriendGroups.AddMember(group, input.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotFriends):
+			v.AddError("id", "must be one of your friends")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend added to group"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFriendGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	group, ok := app.readFriendGroup(w, r, u)
+	if !ok {
+		return
+	}
+
+	memberId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid user id"))
+		return
+	}
+
+	err = app.models.FriendGroups.RemoveMember(group, memberId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("friend not in group"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend removed from group"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/AustinMusiku/Materix-go/internal/data"
 	"github.com/AustinMusiku/Materix-go/internal/validator"
@@ -27,12 +28,17 @@ func (app *application) getMyFriendsHandler(w http.ResponseWriter, r *http.Reque
 		SortSafelist: []string{"id", "updated_at", "-id", "-updated_at"},
 	}
 
+	groupId, ok := app.readGroupFilter(w, r, u, queryStrings, v)
+	if !ok {
+		return
+	}
+
 	if data.ValidateFilters(v, filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	friends, meta, err := app.models.Friends.GetFriendsFor(u.Id, filters)
+	friends, meta, err := app.models.Friends.GetFriendsFor(u.Id, groupId, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -79,11 +85,14 @@ func (app *application) sendFriendRequestHandler(w http.ResponseWriter, r *http.
 		case data.ErrDuplicateFriendRequest:
 			v.AddError("id", "friend request between users already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case data.ErrBlocked:
+			app.notFoundResponse(w, r, errors.New("user not found"))
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.forgetSuggestions(u.Id, input.Id)
 
 	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"message": "Friend request sent"}, nil)
 	if err != nil {
@@ -132,6 +141,7 @@ func (app *application) acceptFriendRequestHandler(w http.ResponseWriter, r *htt
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.forgetSuggestions(fRequest.SourceUserId, fRequest.DestinationUserId)
 
 	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend request accepted"}, nil)
 	if err != nil {
@@ -180,6 +190,7 @@ func (app *application) rejectFriendRequestHandler(w http.ResponseWriter, r *htt
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.forgetSuggestions(fRequest.SourceUserId, fRequest.DestinationUserId)
 
 	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend request rejected"}, nil)
 	if err != nil {
@@ -295,6 +306,7 @@ func (app *application) removeFriendHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	app.forgetSuggestions(u.Id, fId)
 
 	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend removed"}, nil)
 	if err != nil {
@@ -337,3 +349,132 @@ func (app *application) searchMyFriendsHandler(w http.ResponseWriter, r *http.Re
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// getMutualFriendsHandler lists the friends that the requesting user has in
+// common with another user whose profile they may see.
+func (app *application) getMutualFriendsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	otherId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid user id"))
+		return
+	}
+
+	v := validator.New()
+	queryStrings := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 10, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	other, err := app.models.Users.GetById(otherId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	visible, _, err := app.profileAccess(u, other)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !visible {
+		app.notFoundResponse(w, r, errors.New("user not found"))
+		return
+	}
+
+	mutual, meta, err := app.models.Friends.GetMutualFor(u.Id, other.Id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "mutual_count": meta.TotalRecords, "mutual_friends": mutual}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+const (
+	// suggestionsTTL is how long a user's friend suggestions are reused. Changes to
+	// the user's own friendships and blocks clear them sooner; changes further out
+	// in their network show up once they expire.
+	suggestionsTTL = 10 * time.Minute
+	// suggestionsCacheSize is the number of users whose suggestions are kept.
+	suggestionsCacheSize = 10_000
+	// maxSuggestions is the number of suggestions ranked for a user, in total over
+	// all pages.
+	maxSuggestions = 100
+)
+
+// getFriendSuggestionsHandler lists the friends of the requesting user's
+// friends, the users they have the most friends in common with first.
+func (app *application) getFriendSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	v := validator.New()
+	queryStrings := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 10, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The ranking is computed once and paged through from the cache
+	suggestions, ok := app.suggestions.Get(u.Id)
+	if !ok {
+		var err error
+		suggestions, err = app.models.Friends.GetSuggestionsFor(u.Id, maxSuggestions)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.suggestions.Set(u.Id, suggestions)
+	}
+
+	page, meta := data.Paginate(suggestions, filters)
+
+	err := app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "suggestions": page}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// forgetSuggestions drops the cached friend suggestions of the given users, for
+// when their friendships or blocks change.
+func (app *application) forgetSuggestions(userIds ...int) {
+	for _, id := range userIds {
+		app.suggestions.Delete(id)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -130,3 +131,64 @@ func (app *application) readDate(qs url.Values, key string, defaultValue string)
 
 	return t
 }
+
+// clientIP returns the address of the client without its port. Behind a trusted
+// proxy the address is the one the proxy reported, see realIP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// background runs fn in a goroutine that the server waits for before shutting down.
+// Panics are recovered and logged rather than crashing the application.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// cleanUp permanently deletes the users whose restore window has passed, with
+// their avatars, and the data exports that expired, every interval until stop is closed.
+func (app *application) cleanUp(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.models.Users.PurgeDeleted()
+		if err != nil {
+			app.logger.Error(err, nil)
+		} else if len(purged) > 0 {
+			app.logger.Info("Purged deleted users", map[string]string{
+				"count": strconv.Itoa(len(purged)),
+			})
+
+			for _, uuid := range purged {
+				app.deleteUserAvatars(uuid)
+			}
+		}
+
+		err = app.models.DataExports.DeleteExpired()
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+func (app *application) getMyIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// linkIdentityHandler links a new login method to the user. An email login is
+// linked directly by setting a password, while an OAuth provider returns the url
+// that the browser must visit to authorize the link; the provider's callback then
+// completes it.
+func (app *application) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Provider string `json:"provider"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Provider != "", "provider", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Provider != data.ProviderEmail {
+		provider, ok := app.oauth.Get(input.Provider)
+		if !ok {
+			v.AddError("provider", "must be a supported login provider")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		authReq, err := app.oauthFlow.BeginLink(w, provider.Name(), claims.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, ResponseWrapper{"authorization_url": provider.AuthCodeURL(authReq)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetById(claims.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = u.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	identity, err := app.models.Identities.LinkEmail(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateIdentity):
+			v.AddError("provider", "an email login is already linked to this account")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"identity": identity}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		app.badRequestResponse(w, r, errors.New("missing or invalid identity id"))
+		return
+	}
+
+	identityId, err := strconv.Atoi(id)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.Delete(u.Id, identityId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("identity not found"))
+		case errors.Is(err, data.ErrLastIdentity):
+			v := validator.New()
+			v.AddError("id", "cannot unlink the only login method of the account")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Login method unlinked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/go-chi/chi"
+)
+
+// createFriendInviteHandler creates an invite that makes whoever redeems it a
+// friend of the requesting user. Invites are single-use unless max_uses says
+// otherwise, with 0 allowing any number of uses until the invite expires.
+func (app *application) createFriendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		MaxUses *int       `json:"max_uses"`
+		Expiry  *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invite := &data.FriendInvite{
+		MaxUses: input.MaxUses,
+		Expiry:  time.Now().Add(data.DefaultInviteLifetime),
+	}
+
+	if invite.MaxUses == nil {
+		one := 1
+		invite.MaxUses = &one
+	}
+
+	if input.Expiry != nil {
+		invite.Expiry = *input.Expiry
+	}
+
+	v := validator.New()
+	if data.ValidateFriendInvite(v, invite); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if *invite.MaxUses == 0 {
+		invite.MaxUses = nil
+	}
+
+	invite, err = app.models.FriendInvites.New(u.Id, invite.MaxUses, invite.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	invite.Link = app.inviteLink(invite.Code)
+
+	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"invite": invite}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getMyFriendInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	queryStrings := r.URL.Query()
+	v := validator.New()
+
+	filters := data.Filters{
+		Page:         app.readInt(queryStrings, "page", 1, v),
+		PageSize:     app.readInt(queryStrings, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invites, meta, err := app.models.FriendInvites.GetAllFor(u.Id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, invite := range invites {
+		invite.Link = app.inviteLink(invite.Code)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"meta": meta, "invites": invites}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showFriendInviteHandler serves the links of invites. It shows who an invite is
+// from and where to accept it: users with an account redeem it, and new users
+// sign up with its code in the invite field of a signup or the invite parameter
+// of an oauth login.
+func (app *application) showFriendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	code := strings.ToLower(chi.URLParam(r, "code"))
+
+	v := validator.New()
+	if data.ValidateInviteCode(v, code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invite, err := app.models.FriendInvites.GetUsable(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("invite not found or no longer valid"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	inviter, err := app.models.Users.GetById(invite.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("invite not found or no longer valid"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if viewer.Id != 0 {
+		blocked, err := app.models.Blocks.IsBlocked(viewer.Id, inviter.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if blocked {
+			app.notFoundResponse(w, r, errors.New("invite not found or no longer valid"))
+			return
+		}
+	}
+
+	// Whoever holds the code may see who it is from, but not their email address
+	inviter.Email = ""
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{
+		"inviter":    inviter,
+		"expiry":     invite.Expiry,
+		"redeem_url": app.config.baseURL + "/api/friends/invites/" + code + "/redeem",
+		"signup_url": app.config.baseURL + "/api/auth/signup",
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeFriendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or invalid invite id"))
+		return
+	}
+
+	err = app.models.FriendInvites.Revoke(u.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("invite not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Invite revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) redeemFriendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	code := strings.ToLower(chi.URLParam(r, "code"))
+
+	v := validator.New()
+	if data.ValidateInviteCode(v, code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.redeemFriendInvite(code, u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrOwnInvite):
+			v.AddError("code", "you can't redeem your own invite")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAlreadyFriends):
+			v.AddError("code", "you are already friends with this user")
+			app.failedValidationResponse(w, r, v.Errors)
+		// blocked users can't tell their invite apart from a missing one
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrBlocked):
+			app.notFoundResponse(w, r, errors.New("invite not found or no longer valid"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Friend added"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeemFriendInvite makes the user friends with the creator of the invite.
+func (app *application) redeemFriendInvite(code string, userId int) error {
+	invite, err := app.models.FriendInvites.Redeem(code, userId)
+	if err != nil {
+		return err
+	}
+
+	app.forgetSuggestions(invite.UserId, userId)
+	return nil
+}
+
+// inviteLink returns the link that shows the invite with the code, see
+// showFriendInviteHandler.
+func (app *application) inviteLink(code string) string {
+	return app.config.baseURL + "/invite/" + code
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/lockout"
+)
+
+// mfaTokenAttempts is the number of wrong second factors that an mfa token may be
+// presented with before it stops being accepted.
+const mfaTokenAttempts = 5
+
+// loginGuards throttle failed password logins per account and per client address.
+// Addresses get more attempts than accounts since many users can share one.
+// Failed second factors are throttled per user, and per mfa token, on top.
+type loginGuards struct {
+	accounts      *lockout.Guard
+	ips           *lockout.Guard
+	secondFactors *lockout.Guard
+	mfaTokens     *lockout.Guard
+}
+
+func (app *application) newLoginGuards(store lockout.Store) loginGuards {
+	accounts := lockout.NewGuard("account", store, lockout.Policy{
+		Free:      3,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Threshold: app.config.lockout.threshold,
+		Lockout:   app.config.lockout.duration,
+		Window:    24 * time.Hour,
+	})
+	accounts.OnLockout = app.notifyAccountLocked
+
+	ips := lockout.NewGuard("ip", store, lockout.Policy{
+		Free:      10,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Threshold: 5 * app.config.lockout.threshold,
+		Lockout:   app.config.lockout.duration,
+		Window:    24 * time.Hour,
+	})
+	ips.OnLockout = func(ip string, until time.Time) {
+		app.logger.Warn("Client address locked out after failed logins", map[string]string{
+			"ip":    ip,
+			"until": until.Format(time.RFC3339),
+		})
+	}
+
+	secondFactors := lockout.NewGuard("mfa", store, lockout.Policy{
+		Free:      3,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Threshold: app.config.lockout.threshold,
+		Lockout:   app.config.lockout.duration,
+		Window:    24 * time.Hour,
+	})
+	secondFactors.OnLockout = app.notifySecondFactorLocked
+
+	mfaTokens := lockout.NewGuard("mfa_token", store, lockout.Policy{
+		Free:      mfaTokenAttempts,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Second,
+		Threshold: mfaTokenAttempts,
+		Lockout:   data.MFATokenTTL,
+		Window:    data.MFATokenTTL,
+	})
+
+	return loginGuards{accounts: accounts, ips: ips, secondFactors: secondFactors, mfaTokens: mfaTokens}
+}
+
+// checkLogin returns how long a login to the account from r's address has to wait.
+func (app *application) checkLogin(r *http.Request, email string) (time.Duration, error) {
+	accountWait, err := app.logins.accounts.Check(strings.ToLower(email))
+	if err != nil {
+		return 0, err
+	}
+
+	ipWait, err := app.logins.ips.Check(clientIP(r))
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// failLogin counts a failed login against both the account and r's address.
+func (app *application) failLogin(r *http.Request, email string) error {
+	_, err := app.logins.accounts.Fail(strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+
+	_, err = app.logins.ips.Fail(clientIP(r))
+	return err
+}
+
+// succeedLogin clears the account's failures. The address keeps its count so that
+// logging in to an account of one's own doesn't reset guessing at others.
+func (app *application) succeedLogin(email string) error {
+	return app.logins.accounts.Reset(strings.ToLower(email))
+}
+
+// checkSecondFactor returns how long a second factor for the user from r's address
+// has to wait.
+func (app *application) checkSecondFactor(r *http.Request, userId int) (time.Duration, error) {
+	userWait, err := app.logins.secondFactors.Check(strconv.Itoa(userId))
+	if err != nil {
+		return 0, err
+	}
+
+	ipWait, err := app.logins.ips.Check(clientIP(r))
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > userWait {
+		return ipWait, nil
+	}
+	return userWait, nil
+}
+
+// failSecondFactor counts a wrong second factor against the user, r's address and
+// the mfa token it was presented with, if any.
+func (app *application) failSecondFactor(r *http.Request, userId int, jti string) error {
+	_, err := app.logins.secondFactors.Fail(strconv.Itoa(userId))
+	if err != nil {
+		return err
+	}
+
+	if jti != "" {
+		_, err = app.logins.mfaTokens.Fail(jti)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = app.logins.ips.Fail(clientIP(r))
+	return err
+}
+
+// succeedSecondFactor clears the user's failed second factors.
+func (app *application) succeedSecondFactor(userId int) error {
+	return app.logins.secondFactors.Reset(strconv.Itoa(userId))
+}
+
+// notifyAccountLocked emails the owner of a locked account, if there is one, so
+// that they know someone is guessing their password.
+func (app *application) notifyAccountLocked(email string, until time.Time) {
+	app.background(func() {
+		u, err := app.models.Users.GetByEmail(email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error(err, nil)
+			}
+			return
+		}
+
+		app.logger.Warn("Account locked out after failed logins", map[string]string{
+			"user_id": strconv.Itoa(u.Id),
+			"until":   until.Format(time.RFC3339),
+		})
+
+		mailData := map[string]any{
+			"name":  u.Name,
+			"until": until.UTC().Format("Jan 2, 2006 at 15:04 MST"),
+		}
+
+		err = app.mailer.Send(u.Email, "account_locked.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		}
+	})
+}
+
+// notifySecondFactorLocked emails the user whose second factor was guessed wrong
+// too often. Whoever is guessing already knows their password.
+func (app *application) notifySecondFactorLocked(key string, until time.Time) {
+	app.background(func() {
+		userId, err := strconv.Atoi(key)
+		if err != nil {
+			app.logger.Error(err, nil)
+			return
+		}
+
+		u, err := app.models.Users.GetAnyById(userId)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error(err, nil)
+			}
+			return
+		}
+
+		app.logger.Warn("Second factor locked out after failed logins", map[string]string{
+			"user_id": key,
+			"until":   until.Format(time.RFC3339),
+		})
+
+		mailData := map[string]any{
+			"name":  u.Name,
+			"until": until.UTC().Format("Jan 2, 2006 at 15:04 MST"),
+		}
+
+		err = app.mailer.Send(u.Email, "second_factor_locked.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": key})
+		}
+	})
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strconv"
@@ -16,38 +18,107 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/AustinMusiku/Materix-go/internal/auth"
+	"github.com/AustinMusiku/Materix-go/internal/blob"
+	"github.com/AustinMusiku/Materix-go/internal/cache"
 	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/lockout"
 	"github.com/AustinMusiku/Materix-go/internal/logger"
+	"github.com/AustinMusiku/Materix-go/internal/mailer"
+	"github.com/AustinMusiku/Materix-go/internal/signing"
 	_ "github.com/lib/pq"
 )
 
 type config struct {
-	port int
-	env  string
-	log  struct {
+	port    int
+	env     string
+	baseURL string
+	log     struct {
 		minLevel logger.Level
 	}
 	db struct {
 		dsn string
 	}
 	jwt struct {
-		secret string
+		secret   string
+		keyFiles []string
 	}
 	cors struct {
 		allowedOrigins []string
 	}
-	limiter struct {
+	trustedProxies []netip.Prefix
+	limiter        struct {
 		rps     int
 		wl      int
 		enabled bool
 	}
+	authLimiter struct {
+		requests int
+		wl       int
+		enabled  bool
+	}
+	lockout struct {
+		store     string
+		threshold int
+		duration  time.Duration
+	}
+	avatars struct {
+		store    string
+		dir      string
+		maxBytes int64
+	}
+	s3 struct {
+		endpoint        string
+		region          string
+		bucket          string
+		accessKeyId     string
+		secretAccessKey string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	oauth struct {
+		redirectBaseURL string
+		stateSecret     string
+		clients         map[string]*oauthClient
+	}
+	oidc struct {
+		name         string
+		issuer       string
+		clientId     string
+		clientSecret string
+	}
+}
+
+type oauthClient struct {
+	id     string
+	secret string
+}
+
+// oauthProviders maps the name of every supported OAuth provider to its constructor.
+var oauthProviders = map[string]func(auth.Config) *auth.OAuth2Provider{
+	"github":    auth.NewGitHub,
+	"gitlab":    auth.NewGitLab,
+	"google":    auth.NewGoogle,
+	"microsoft": auth.NewMicrosoft,
 }
 
 type application struct {
-	config config
-	logger *logger.Logger
-	models data.Models
-	wg     sync.WaitGroup
+	config      config
+	logger      *logger.Logger
+	models      data.Models
+	mailer      mailer.Mailer
+	keys        *signing.KeySet
+	oauth       *auth.Registry
+	oauthFlow   *auth.FlowManager
+	logins      loginGuards
+	avatars     blob.Store
+	suggestions *cache.Cache[int, []*data.Suggestion]
+	wg          sync.WaitGroup
 }
 
 func main() {
@@ -64,12 +135,44 @@ func main() {
 	defer db.Close()
 	logger.Info("Database connection pool established", nil)
 
+	oauthFlow, err := newOAuthFlowManager(config, logger)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
+	keys, err := newKeySet(config)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
+	oauth, err := newOAuthRegistry(config)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
+	avatars, err := newAvatarStore(config)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
 	app := &application{
-		config: config,
-		logger: logger,
-		models: data.NewModels(db),
-		wg:     sync.WaitGroup{},
+		config:      config,
+		logger:      logger,
+		models:      data.NewModels(db),
+		mailer:      newMailer(config),
+		keys:        keys,
+		oauth:       oauth,
+		oauthFlow:   oauthFlow,
+		avatars:     avatars,
+		suggestions: cache.New[int, []*data.Suggestion](suggestionsTTL, suggestionsCacheSize),
+		wg:          sync.WaitGroup{},
+	}
+
+	lockoutStore, err := newLockoutStore(config, &app.models)
+	if err != nil {
+		logger.Fatal(err, nil)
 	}
+	app.logins = app.newLoginGuards(lockoutStore)
 
 	err = app.serve()
 	if err != nil {
@@ -93,6 +196,11 @@ func (app *application) serve() error {
 	})
 
 	shutdownErr := make(chan error)
+	stopJobs := make(chan struct{})
+
+	app.background(func() {
+		app.cleanUp(stopJobs, time.Hour)
+	})
 
 	go func() {
 		sigChan := make(chan os.Signal, 1)
@@ -113,6 +221,7 @@ func (app *application) serve() error {
 
 		app.logger.Info("Waiting for background processes to finish", nil)
 
+		close(stopJobs)
 		app.wg.Wait()
 		shutdownErr <- nil
 	}()
@@ -145,6 +254,7 @@ func configure() config {
 
 	flag.IntVar(&config.port, "port", defaultPort, "Application service port")
 	flag.StringVar(&config.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&config.baseURL, "base-url", os.Getenv("BASE_URL"), "Public base URL of the api, used in the links given to users (defaults to http://localhost:<port>)")
 
 	flag.IntVar((*int)(&config.log.minLevel), "log-level", int(logger.LevelDebug), "Minimum log level (0=DEBUG, 1=INFO, 2=WARN, 3=ERROR, 4=FATAL)")
 
@@ -152,20 +262,249 @@ func configure() config {
 
 	flag.StringVar(&config.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "JWT secret key")
 
+	config.jwt.keyFiles = strings.Fields(os.Getenv("JWT_SIGNING_KEYS"))
+	flag.Func("jwt-signing-keys", "PEM files with RS256 or EdDSA JWT signing keys; the first signs and the rest only verify", func(val string) error {
+		config.jwt.keyFiles = strings.Fields(val)
+		return nil
+	})
+
 	flag.Func("cors-allowed-origins", "CORS allowed origins", func(val string) error {
 		config.cors.allowedOrigins = strings.Fields(val)
 		return nil
 	})
 
+	config.trustedProxies, _ = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	flag.Func("trusted-proxies", "Addresses or CIDR ranges of the proxies whose X-Forwarded-For and X-Real-IP headers are believed", func(val string) error {
+		proxies, err := parseTrustedProxies(val)
+		if err != nil {
+			return err
+		}
+		config.trustedProxies = proxies
+		return nil
+	})
+
 	flag.IntVar(&config.limiter.rps, "limiter-rps", 10, "Rate limiter requests per second")
 	flag.IntVar(&config.limiter.wl, "limiter-wl", 1, "Rate limiter window length in seconds")
 	flag.BoolVar(&config.limiter.enabled, "limiter-enabled", false, "Enable rate limiter")
 
+	flag.IntVar(&config.authLimiter.requests, "auth-limiter-requests", 20, "Auth rate limiter requests per window")
+	flag.IntVar(&config.authLimiter.wl, "auth-limiter-wl", 60, "Auth rate limiter window length in seconds")
+	flag.BoolVar(&config.authLimiter.enabled, "auth-limiter-enabled", true, "Enable the stricter rate limiter on /api/auth routes")
+
+	flag.StringVar(&config.lockout.store, "lockout-store", "postgres", "Where failed login counters are kept (memory|postgres)")
+	flag.IntVar(&config.lockout.threshold, "lockout-threshold", 10, "Failed logins that temporarily lock out an account")
+	flag.DurationVar(&config.lockout.duration, "lockout-duration", 15*time.Minute, "How long an account stays locked out")
+
+	flag.StringVar(&config.avatars.store, "avatar-store", "file", "Where uploaded avatars are stored (file|s3)")
+	flag.StringVar(&config.avatars.dir, "avatar-dir", "./uploads", "Directory uploaded avatars are stored in by the file store")
+	flag.Int64Var(&config.avatars.maxBytes, "avatar-max-bytes", 5<<20, "Largest avatar upload accepted, in bytes")
+
+	defaultS3Region := "us-east-1"
+	if os.Getenv("S3_REGION") != "" {
+		defaultS3Region = os.Getenv("S3_REGION")
+	}
+
+	flag.StringVar(&config.s3.endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 compatible endpoint avatars are stored at by the s3 store")
+	flag.StringVar(&config.s3.region, "s3-region", defaultS3Region, "S3 region")
+	flag.StringVar(&config.s3.bucket, "s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&config.s3.accessKeyId, "s3-access-key-id", os.Getenv("S3_ACCESS_KEY_ID"), "S3 access key id")
+	flag.StringVar(&config.s3.secretAccessKey, "s3-secret-access-key", os.Getenv("S3_SECRET_ACCESS_KEY"), "S3 secret access key")
+
+	defaultSMTPPort := 587
+	if os.Getenv("SMTP_PORT") != "" {
+		p, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err == nil {
+			defaultSMTPPort = p
+		}
+	}
+
+	flag.StringVar(&config.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host (emails are logged to stdout when empty)")
+	flag.IntVar(&config.smtp.port, "smtp-port", defaultSMTPPort, "SMTP port")
+	flag.StringVar(&config.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&config.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&config.smtp.sender, "smtp-sender", "Materix <no-reply@materix.app>", "SMTP sender")
+
+	defaultRedirectBaseURL := "https://materix.up.railway.app"
+	if os.Getenv("OAUTH_REDIRECT_BASE_URL") != "" {
+		defaultRedirectBaseURL = os.Getenv("OAUTH_REDIRECT_BASE_URL")
+	}
+
+	flag.StringVar(&config.oauth.redirectBaseURL, "oauth-redirect-base-url", defaultRedirectBaseURL, "Base URL that OAuth providers redirect back to")
+	flag.StringVar(&config.oauth.stateSecret, "oauth-state-secret", os.Getenv("OAUTH_STATE_SECRET"), "Key used to sign OAuth login state cookies")
+
+	config.oauth.clients = make(map[string]*oauthClient)
+	for name := range oauthProviders {
+		client := &oauthClient{}
+		env := strings.ToUpper(name)
+
+		flag.StringVar(&client.id, "oauth-"+name+"-client-id", os.Getenv(env+"_CLIENT_ID"), fmt.Sprintf("OAuth client id for %s (disabled when empty)", name))
+		flag.StringVar(&client.secret, "oauth-"+name+"-client-secret", os.Getenv(env+"_CLIENT_SECRET"), fmt.Sprintf("OAuth client secret for %s", name))
+
+		config.oauth.clients[name] = client
+	}
+
+	flag.StringVar(&config.oidc.name, "oidc-name", "oidc", "Name of the OpenID Connect provider in login routes")
+	flag.StringVar(&config.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "OpenID Connect issuer URL (disabled when empty)")
+	flag.StringVar(&config.oidc.clientId, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OpenID Connect client id")
+	flag.StringVar(&config.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+
 	flag.Parse()
 
+	if config.baseURL == "" {
+		config.baseURL = fmt.Sprintf("http://localhost:%d", config.port)
+	}
+	config.baseURL = strings.TrimSuffix(config.baseURL, "/")
+
 	return config
 }
 
+// parseTrustedProxies parses a space-separated list of addresses and CIDR ranges.
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func newMailer(cfg config) mailer.Mailer {
+	if cfg.smtp.host == "" {
+		return mailer.NewLogMailer(os.Stdout, cfg.smtp.sender)
+	}
+
+	return mailer.NewSMTPMailer(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+}
+
+// newAvatarStore returns the store that uploaded avatars are kept in. The file
+// store only suits a single instance of the api, or a shared volume.
+func newAvatarStore(cfg config) (blob.Store, error) {
+	switch cfg.avatars.store {
+	case "file":
+		return blob.NewFileStore(cfg.avatars.dir), nil
+	case "s3":
+		if cfg.s3.endpoint == "" || cfg.s3.bucket == "" {
+			return nil, errors.New("the s3 avatar store needs an endpoint and a bucket")
+		}
+
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:        cfg.s3.endpoint,
+			Region:          cfg.s3.region,
+			Bucket:          cfg.s3.bucket,
+			AccessKeyId:     cfg.s3.accessKeyId,
+			SecretAccessKey: cfg.s3.secretAccessKey,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown avatar store %q", cfg.avatars.store)
+	}
+}
+
+// newLockoutStore returns the store that failed login counters are kept in. The
+// memory store only suits a single instance of the api.
+func newLockoutStore(cfg config, models *data.Models) (lockout.Store, error) {
+	switch cfg.lockout.store {
+	case "memory":
+		return lockout.NewMemoryStore(), nil
+	case "postgres":
+		return &models.LoginAttempts, nil
+	default:
+		return nil, fmt.Errorf("unknown lockout store %q", cfg.lockout.store)
+	}
+}
+
+// newKeySet loads the keys that JWTs are signed with. The first key file signs new
+// tokens, the other files and the shared secret only verify tokens issued before a
+// key was rotated.
+func newKeySet(cfg config) (*signing.KeySet, error) {
+	var keys []*signing.Key
+
+	for _, path := range cfg.jwt.keyFiles {
+		key, err := signing.LoadPEMFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if cfg.jwt.secret != "" {
+		keys = append(keys, signing.NewHMACKey([]byte(cfg.jwt.secret)))
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT signing keys or secret configured")
+	}
+
+	return signing.NewKeySet(keys...)
+}
+
+func newOAuthRegistry(cfg config) (*auth.Registry, error) {
+	registry := auth.NewRegistry()
+	redirectBaseURL := strings.TrimSuffix(cfg.oauth.redirectBaseURL, "/")
+
+	for name, newProvider := range oauthProviders {
+		client := cfg.oauth.clients[name]
+		if client.id == "" {
+			continue
+		}
+
+		registry.Register(newProvider(auth.Config{
+			ClientId:     client.id,
+			ClientSecret: client.secret,
+			RedirectURL:  fmt.Sprintf("%s/api/auth/%s/callback", redirectBaseURL, name),
+		}))
+	}
+
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		provider, err := auth.NewOIDC(ctx, cfg.oidc.name, cfg.oidc.issuer, auth.Config{
+			ClientId:     cfg.oidc.clientId,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  fmt.Sprintf("%s/api/auth/%s/callback", redirectBaseURL, cfg.oidc.name),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		registry.Register(provider)
+	}
+
+	return registry, nil
+}
+
+func newOAuthFlowManager(cfg config, logger *logger.Logger) (*auth.FlowManager, error) {
+	key := []byte(cfg.oauth.stateSecret)
+
+	if len(key) == 0 {
+		// Logins started before a restart, or on another instance, can't be
+		// completed with a random key, so only fall back to one when unset.
+		logger.Warn("No OAuth state secret configured, using a random key", nil)
+
+		key = make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return auth.NewFlowManager(key, cfg.env != "development"), nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
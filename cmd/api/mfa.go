@@ -0,0 +1,554 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/totp"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+// totpSkew is the number of 30 second steps that an authenticator's clock may be
+// off by.
+const totpSkew = 1
+
+// loginResponse completes a login after the user proved their first factor. Users
+// with two-factor authentication get a challenge token to present together with a
+// code, everyone else gets a new session with the requested scopes right away.
+func (app *application) loginResponse(w http.ResponseWriter, r *http.Request, u *data.User, provider string, scopes []string) {
+	// Deleted accounts can only be restored with the token emailed on deletion
+	if u.IsDeleted() {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if u.IsSuspended() {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(u.Id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled {
+		mfaToken, err := data.NewMFAToken(app.keys, u.Id, provider, scopes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"mfa_required": true, "mfa_token": mfaToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tokens, err := app.newSession(r, u, provider, scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code, for the
+// user's enabled second factor. Both can only be used once.
+func (app *application) verifySecondFactor(mfa *data.MFA, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(mfa.Secret, code, time.Now(), totpSkew)
+		if !ok {
+			return false, nil
+		}
+
+		err := app.models.MFA.UseStep(mfa.UserId, step)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	err := app.models.MFA.UseRecoveryCode(mfa.UserId, recoveryCode)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// confirmSecondFactor checks the second factor a logged in user gave to confirm a
+// change to their two-factor authentication, throttled like the second factor of
+// a login.
+func (app *application) confirmSecondFactor(w http.ResponseWriter, r *http.Request, mfa *data.MFA, code, recoveryCode string) bool {
+	retryAfter, err := app.checkSecondFactor(r, mfa.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return false
+	}
+
+	ok, err := app.verifySecondFactor(mfa, code, recoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		app.failedSecondFactorResponse(w, r, mfa.UserId, "")
+		return false
+	}
+
+	err = app.succeedSecondFactor(mfa.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+// failedSecondFactorResponse counts a wrong second factor before rejecting it.
+func (app *application) failedSecondFactorResponse(w http.ResponseWriter, r *http.Request, userId int, jti string) {
+	err := app.failSecondFactor(r, userId, jti)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// validateSecondFactorInput requires exactly one of a TOTP code or a recovery code.
+func validateSecondFactorInput(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code == "" || recoveryCode == "", "code", "must not be provided together with a recovery code")
+	if recoveryCode == "" {
+		data.ValidateTOTPCode(v, code)
+	}
+}
+
+func (app *application) mfaLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.MFAToken != "", "mfa_token", "must be provided")
+	validateSecondFactorInput(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := data.ParseMFAToken(app.keys, input.MFAToken)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if ok, err := claims.Verify(); err != nil || !ok {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	userId, err := strconv.Atoi(claims.Sub)
+	if err != nil || claims.Jti == "" {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// An mfa token completes a single login and survives only a few wrong guesses
+	used, err := app.models.Tokens.IsTokenUsed(claims.Jti)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokenWait, err := app.logins.mfaTokens.Check(claims.Jti)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if used || tokenWait > 0 {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	retryAfter, err := app.checkSecondFactor(r, userId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !mfa.Enabled {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.failedSecondFactorResponse(w, r, userId, claims.Jti)
+		return
+	}
+
+	claimed, err := app.models.Tokens.UseToken(userId, claims.Jti, claims.Exp.Time)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !claimed {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err = app.succeedSecondFactor(userId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	u, err := app.models.Users.GetAnyById(userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if u.IsDeleted() {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if u.IsSuspended() {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	tokens, err := app.newSession(r, u, claims.Provider, claims.Scopes())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getMyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			mfa = &data.MFA{UserId: u.Id}
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"mfa": mfa}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrollMFAHandler generates a new TOTP secret for the user. Two-factor
+// authentication isn't enabled until the user confirms it with a code.
+func (app *application) enrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enroll(u.Id, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAEnabled):
+			v := validator.New()
+			v.AddError("mfa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	enrolment := map[string]string{
+		"secret": secret,
+		"uri":    totp.URI("Materix", u.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, ResponseWrapper{"mfa": enrolment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmMFAHandler enables two-factor authentication once the user proves that
+// their authenticator generates valid codes, and returns their recovery codes.
+func (app *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("no two-factor enrolment found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled {
+		v.AddError("mfa", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		v.AddError("code", "is invalid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Confirm(u.Id, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Users that signed up through an oauth provider may not have a password
+	v := validator.New()
+	if u.Password.IsSet() {
+		v.Check(input.Password != "", "password", "must be provided")
+	}
+	if validateSecondFactorInput(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if u.Password.IsSet() && !app.confirmPassword(w, r, u, input.Password) {
+		return
+	}
+
+	// A pending enrolment can be discarded without a code
+	if mfa.Enabled && !app.confirmSecondFactor(w, r, mfa, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	err = app.models.MFA.Delete(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the user's recovery codes, e.g. after
+// they have used most of them or suspect they were seen by someone else.
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !mfa.Enabled {
+		app.notFoundResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		return
+	}
+
+	if !app.confirmSecondFactor(w, r, mfa, input.Code, "") {
+		return
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.ReplaceRecoveryCodes(u.Id, hashes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
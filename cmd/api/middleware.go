@@ -4,15 +4,22 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/go-chi/httprate"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	claimsContextKey = contextKey("claims")
+	apiKeyContextKey = contextKey("apiKey")
+)
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,7 +36,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		token := bearer[7:]
-		claims, err := data.ParseAccessToken(token)
+		if data.IsAPIKey(token) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		claims, err := data.ParseAccessToken(app.keys, token)
 		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -47,7 +59,18 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		u, err := app.models.Users.GetById(id)
+		revoked, err := app.models.Tokens.IsRevoked(id, claims)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if revoked {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		u, err := app.models.Users.GetAnyById(id)
 		if err != nil {
 			switch err {
 			case data.ErrRecordNotFound:
@@ -58,11 +81,89 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if u.IsDeleted() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		if u.IsSuspended() {
+			app.suspendedAccountResponse(w, r)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), userContextKey, u)
+		ctx = context.WithValue(ctx, claimsContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticateAPIKey authenticates a request made with an API key instead of an
+// access token.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	key, err := app.models.APIKeys.GetForToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	u, err := app.models.Users.GetAnyById(key.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if u.IsDeleted() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if u.IsSuspended() {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Touch(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, u)
+	ctx = context.WithValue(ctx, apiKeyContextKey, key)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requireScope restricts a route to credentials that grant the scope: the scopes
+// of an API key, or those of an access token. Unauthenticated requests are left
+// to requireAuthentication.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey); ok && !key.HasScope(scope) {
+				app.insufficientScopeResponse(w, r, scope)
+				return
+			}
+
+			if claims, ok := r.Context().Value(claimsContextKey).(*data.UserClaims); ok && !data.HasScope(claims.Scopes(), scope) {
+				app.insufficientScopeResponse(w, r, scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		u, ok := r.Context().Value(userContextKey).(*data.User)
@@ -73,3 +174,110 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireRole restricts a route to users whose role is role or one above it.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := r.Context().Value(userContextKey).(*data.User)
+			if !ok || u.CreatedAt == "" {
+				app.authenticationRequiredResponse(w, r)
+				return
+			}
+
+			if !u.HasRole(role) {
+				app.notPermittedResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := r.Context().Value(userContextKey).(*data.User)
+		if !ok || u.CreatedAt == "" {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		if !u.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// realIP replaces the remote address of requests that come through a trusted
+// proxy with the client address that the proxy reported. The X-Forwarded-For
+// chain is read from the right, skipping trusted proxies, since anything to the
+// left of the last proxy's entry was sent by the client and can't be believed.
+// Headers of requests from anywhere else are ignored.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isTrustedProxy(clientIP(r)) {
+			if ip := app.forwardedIP(r); ip != "" {
+				r.RemoteAddr = ip
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP returns the client address reported by the trusted proxies in
+// front of the api, or "" when they didn't report a valid one.
+func (app *application) forwardedIP(r *http.Request) string {
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				return ""
+			}
+
+			if i == 0 || !app.isTrustedProxy(addr.String()) {
+				return addr.String()
+			}
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.String()
+	}
+
+	return ""
+}
+
+func (app *application) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, proxy := range app.config.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimit allows each client address the given number of requests per window of
+// wl seconds.
+func (app *application) rateLimit(requests, wl int) func(http.Handler) http.Handler {
+	return httprate.Limit(
+		requests,
+		time.Duration(wl)*time.Second,
+		httprate.WithKeyByIP(),
+		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			app.rateLimitExceededResponse(w, r)
+		}),
+	)
+}
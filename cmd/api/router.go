@@ -2,29 +2,23 @@ package main
 
 import (
 	"net/http"
-	"time"
 
+	"github.com/AustinMusiku/Materix-go/internal/data"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
 )
 
 func (app *application) initRouter() *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(httprate.Limit(
-		app.config.limiter.rps,
-		time.Duration(app.config.limiter.wl)*time.Second,
-		httprate.WithKeyByRealIP(),
-		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
-			app.rateLimitExceededResponse(w, r)
-		}),
-	))
+	if app.config.limiter.enabled {
+		r.Use(app.rateLimit(app.config.limiter.rps, app.config.limiter.wl))
+	}
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: app.config.cors.allowedOrigins,
 	}))
@@ -36,39 +30,153 @@ func (app *application) initRouter() *chi.Mux {
 		w.Write([]byte("Welcome to the Materix!"))
 	})
 
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+	r.Get("/invite/{code}", app.showFriendInviteHandler)
+
 	r.Route("/api", func(r chi.Router) {
-		r.Get("/auth/callback", app.oauthCallbackHandler)
-		r.Post("/auth/signup", app.registerUserHandler)
-		r.Post("/auth/login", app.authenticateUserHandler)
+		r.Group(func(r chi.Router) {
+			// stricter rate limit against credential guessing and mass signups
+			if app.config.authLimiter.enabled {
+				r.Use(app.rateLimit(app.config.authLimiter.requests, app.config.authLimiter.wl))
+			}
+
+			r.Get("/auth/{provider}/login", app.oauthLoginHandler)
+			r.Get("/auth/{provider}/callback", app.oauthCallbackHandler)
+			r.Post("/auth/signup", app.registerUserHandler)
+			r.Post("/auth/login", app.authenticateUserHandler)
+			r.Post("/auth/login/mfa", app.mfaLoginHandler)
+			r.Post("/auth/refresh", app.refreshTokenHandler)
+			r.Post("/auth/password-reset", app.createPasswordResetTokenHandler)
+			r.Put("/auth/password", app.resetPasswordHandler)
+		})
 
 		r.Get("/users/{id}", app.getUserHandler)
+		r.Get("/users/by-handle/{handle}", app.getUserByHandleHandler)
+		r.Get("/users/handle-available", app.checkHandleHandler)
 		r.Get("/users/search", app.searchUsersHandler)
+		r.Put("/users/activated", app.activateUserHandler)
+		r.Post("/users/me/restore", app.restoreUserHandler)
+		r.Get("/exports/download", app.downloadDataExportHandler)
+		r.Get("/avatars/*", app.serveAvatarHandler)
 
 		r.Group(func(r chi.Router) {
 			// require auth
 			r.Use(app.requireAuthentication)
 
-			r.Get("/users/me", app.getMyUserHandler)
-			r.Patch("/users/me", app.updateUserHandler)
-			r.Delete("/users/me", app.deleteUserHandler)
+			r.With(app.requireScope(data.ScopeProfileRead)).Get("/users/me", app.getMyUserHandler)
+			r.With(app.requireScope(data.ScopeProfileWrite)).Patch("/users/me", app.updateUserHandler)
+			r.With(app.requireScope(data.ScopeProfileWrite)).Post("/users/me/avatar", app.uploadAvatarHandler)
+			r.With(app.requireScope(data.ScopeProfileRead)).Get("/users/me/settings", app.getMySettingsHandler)
+			r.With(app.requireScope(data.ScopeProfileWrite)).Patch("/users/me/settings", app.updateMySettingsHandler)
+
+			r.Group(func(r chi.Router) {
+				// account management is never granted to API keys
+				r.Use(app.requireScope(data.ScopeAccount))
+
+				r.Post("/auth/logout", app.logoutHandler)
+				r.Post("/auth/logout-all", app.logoutAllHandler)
+
+				r.Get("/users/me/sessions", app.getMySessionsHandler)
+				r.Delete("/users/me/sessions/{id}", app.deleteSessionHandler)
+
+				r.Delete("/users/me", app.deleteUserHandler)
+				r.Get("/users/me/export", app.getDataExportHandler)
+				r.Post("/users/me/export", app.createDataExportHandler)
+				r.Patch("/users/me/password", app.changePasswordHandler)
+
+				r.Get("/users/me/identities", app.getMyIdentitiesHandler)
+				r.Post("/users/me/identities", app.linkIdentityHandler)
+				r.Delete("/users/me/identities/{id}", app.unlinkIdentityHandler)
+
+				r.Get("/users/me/mfa", app.getMyMFAHandler)
+				r.Post("/users/me/mfa", app.enrollMFAHandler)
+				r.Put("/users/me/mfa", app.confirmMFAHandler)
+				r.Delete("/users/me/mfa", app.disableMFAHandler)
+				r.Post("/users/me/mfa/recovery-codes", app.regenerateRecoveryCodesHandler)
+
+				r.Get("/users/me/tokens", app.getMyAPIKeysHandler)
+				r.Post("/users/me/tokens", app.createAPIKeyHandler)
+				r.Delete("/users/me/tokens/{id}", app.deleteAPIKeyHandler)
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			// administration is never granted to API keys
+			r.Use(app.requireRole(data.RoleModerator))
+			r.Use(app.requireScope(data.ScopeAccount))
+
+			r.Get("/users", app.listUsersHandler)
+			r.Get("/users/{id}", app.getUserAsAdminHandler)
+			r.Get("/users/{id}/friends", app.getUserFriendsAsAdminHandler)
+			r.Get("/users/{id}/free", app.getUserFreeTimesAsAdminHandler)
+			r.Get("/users/{id}/actions", app.getAdminActionsHandler)
+
+			r.Post("/users/{id}/suspend", app.suspendUserHandler)
+			r.Post("/users/{id}/unsuspend", app.unsuspendUserHandler)
+			r.Post("/users/{id}/logout", app.logoutUserHandler)
+			r.Post("/users/{id}/activation", app.resetActivationHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireRole(data.RoleAdmin))
+
+				r.Get("/actions", app.getAdminActionsHandler)
+				r.Put("/users/{id}/role", app.setUserRoleHandler)
+			})
+		})
+
+		r.Group(func(r chi.Router) {
+			// require an activated account
+			r.Use(app.requireActivatedUser)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireScope(data.ScopeFriendsRead))
+
+				r.Get("/friends", app.getMyFriendsHandler)
+				r.Get("/friends/search", app.searchMyFriendsHandler)
+				r.Get("/friends/suggestions", app.getFriendSuggestionsHandler)
+				r.Get("/users/{id}/mutual", app.getMutualFriendsHandler)
+				r.Get("/friends/requests/sent", app.getSentFriendRequestsHandler)
+				r.Get("/friends/requests/received", app.getReceivedFriendRequestsHandler)
+				r.Get("/users/me/blocks", app.getMyBlocksHandler)
+				r.Get("/friends/groups", app.getMyFriendGroupsHandler)
+				r.Get("/friends/groups/{id}", app.getFriendGroupHandler)
+				r.Get("/friends/invites", app.getMyFriendInvitesHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireScope(data.ScopeFriendsWrite))
+
+				r.Delete("/friends/{id}", app.removeFriendHandler)
+				r.Post("/friends/requests", app.sendFriendRequestHandler)
+				r.Put("/friends/requests/{id}", app.acceptFriendRequestHandler)
+				r.Delete("/friends/requests/{id}", app.rejectFriendRequestHandler)
+				r.Post("/users/{id}/block", app.blockUserHandler)
+				r.Delete("/users/{id}/block", app.unblockUserHandler)
+				r.Post("/friends/groups", app.createFriendGroupHandler)
+				r.Patch("/friends/groups/{id}", app.updateFriendGroupHandler)
+				r.Delete("/friends/groups/{id}", app.deleteFriendGroupHandler)
+				r.Post("/friends/groups/{id}/members", app.addFriendGroupMemberHandler)
+				r.Delete("/friends/groups/{id}/members/{userId}", app.removeFriendGroupMemberHandler)
+				r.Post("/friends/invites", app.createFriendInviteHandler)
+				r.Delete("/friends/invites/{id}", app.revokeFriendInviteHandler)
+				r.Post("/friends/invites/{code}/redeem", app.redeemFriendInviteHandler)
+			})
 
-			r.Get("/friends", app.getMyFriendsHandler)
-			r.Get("/friends/search", app.searchMyFriendsHandler)
-			r.Delete("/friends/{id}", app.removeFriendHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireScope(data.ScopeFreeTimesRead))
 
-			r.Get("/friends/requests/sent", app.getSentFriendRequestsHandler)
-			r.Get("/friends/requests/received", app.getReceivedFriendRequestsHandler)
-			r.Post("/friends/requests", app.sendFriendRequestHandler)
-			r.Put("/friends/requests/{id}", app.acceptFriendRequestHandler)
-			r.Delete("/friends/requests/{id}", app.rejectFriendRequestHandler)
+				r.Get("/free", app.getMyFreeTimesHandler)
+				r.Get("/friends/free", app.getMyFriendsFreeTimesHandler)
+				r.Get("/friends/{id}/free", app.getFriendFreeTimesHandler)
+			})
 
-			r.Get("/free", app.getMyFreeTimesHandler)
-			r.Post("/free", app.addFreeTimeHandler)
-			r.Patch("/free/{id}", app.updateFreeTimeHandler)
-			r.Delete("/free/{id}", app.removeFreeTimeHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireScope(data.ScopeFreeTimesWrite))
 
-			r.Get("/friends/free", app.getMyFriendsFreeTimesHandler)
-			r.Get("/friends/{id}/free", app.getFriendFreeTimesHandler)
+				r.Post("/free", app.addFreeTimeHandler)
+				r.Patch("/free/{id}", app.updateFreeTimeHandler)
+				r.Delete("/free/{id}", app.removeFreeTimeHandler)
+			})
 		})
 	})
 
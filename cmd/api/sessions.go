@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/go-chi/chi"
+)
+
+func (app *application) getMySessionsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	claims, ok := r.Context().Value(claimsContextKey).(*data.UserClaims)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing claims value"))
+		return
+	}
+
+	sessions, err := app.models.Sessions.GetAllForUser(u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.Id == claims.Family
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler logs the user out on one device by revoking the session's
+// token family. Access tokens that were already issued stop working as well.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		app.badRequestResponse(w, r, errors.New("missing or invalid session id"))
+		return
+	}
+
+	session, err := app.models.Sessions.Get(u.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("session not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.RevokeFamily(session.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"message": "Session ended"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AustinMusiku/Materix-go/internal/data"
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+// newSession starts a new login of the user: a token family, the session that
+// describes the device it was made from, and the first token pair of the family.
+func (app *application) newSession(r *http.Request, u *data.User, provider string, scopes []string) (map[string]string, error) {
+	family, err := app.models.RefreshTokens.NewFamily(u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &data.Session{
+		Id:        family,
+		UserId:    u.Id,
+		Provider:  provider,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+
+	err = app.models.Sessions.Insert(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.newTokenPair(u, family, scopes)
+}
+
+// newTokenPair issues an access and refresh token pair in the given token family
+// and persists the refresh token so that it can later be exchanged.
+func (app *application) newTokenPair(u *data.User, family string, scopes []string) (map[string]string, error) {
+	tokens, refreshToken, err := data.NewTokenPair(app.keys, *u, family, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.RefreshTokens.Insert(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// jwksHandler publishes the public keys that tokens are signed with so that other
+// services can verify them. Keys that are being rotated out are listed until they
+// are removed from the configuration.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(app.keys.JWKS())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(js)
+}
+
+// requestedScopes returns the scopes that a login asked for as a space-separated
+// list, or the default scopes when it didn't ask for any.
+func requestedScopes(v *validator.Validator, scope string) []string {
+	if scope == "" {
+		return data.DefaultScopes()
+	}
+
+	scopes := data.ParseScope(scope)
+	data.ValidateScopes(v, "scope", scopes, data.DefaultScopes())
+
+	return scopes
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/AustinMusiku/Materix-go/internal/data"
 	"github.com/AustinMusiku/Materix-go/internal/validator"
@@ -34,12 +35,212 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.profileResponse(w, r, u)
+}
+
+func (app *application) getUserByHandleHandler(w http.ResponseWriter, r *http.Request) {
+	handle := chi.URLParam(r, "handle")
+
+	v := validator.New()
+	if v.Check(validator.Matches(handle, data.HandleRX), "handle", "must be 3 to 30 letters, digits or underscores"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetByHandle(handle)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.profileResponse(w, r, u)
+}
+
+// profileResponse sends the profile of u as the requesting user may see it under
+// u's privacy settings. Profiles hidden from the requesting user, including
+// those of users they blocked or were blocked by, are reported as not found, so
+// they can't tell a hidden user from a missing one.
+func (app *application) profileResponse(w http.ResponseWriter, r *http.Request, u *data.User) {
+	viewer, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	visible, emailVisible, err := app.profileAccess(viewer, u)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !visible {
+		app.notFoundResponse(w, r, errors.New("user not found"))
+		return
+	}
+
+	if !emailVisible {
+		u.Email = ""
+	}
+
 	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// profileAccess reports whether viewer may see the profile of u, and its email,
+// under u's privacy settings. Users who blocked each other can't see either.
+func (app *application) profileAccess(viewer, u *data.User) (visible, emailVisible bool, err error) {
+	settings, err := app.models.Users.GetPrivacy(u.Id)
+	if err != nil {
+		return false, false, err
+	}
+
+	self := viewer.Id == u.Id
+	friend := false
+	if !self && viewer.Id != 0 {
+		blocked, err := app.models.Blocks.IsBlocked(viewer.Id, u.Id)
+		if err != nil {
+			return false, false, err
+		}
+
+		if blocked {
+			return false, false, nil
+		}
+
+		pair, err := app.models.Friends.GetFriend(viewer.Id, u.Id)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return false, false, err
+		}
+		friend = pair != nil && pair.Status == "accepted"
+	}
+
+	return data.CanSee(settings.ProfileVisibility, self, friend), data.CanSee(settings.EmailVisibility, self, friend), nil
+}
+
+func (app *application) getMySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	settings, err := app.models.Users.GetPrivacy(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"settings": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateMySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	var input struct {
+		Discoverable      *bool   `json:"discoverable"`
+		EmailVisibility   *string `json:"email_visibility"`
+		ProfileVisibility *string `json:"profile_visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	settings, err := app.models.Users.GetPrivacy(u.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Discoverable != nil {
+		settings.Discoverable = *input.Discoverable
+	}
+
+	if input.EmailVisibility != nil {
+		settings.EmailVisibility = *input.EmailVisibility
+	}
+
+	if input.ProfileVisibility != nil {
+		settings.ProfileVisibility = *input.ProfileVisibility
+	}
+
+	v := validator.New()
+	if data.ValidatePrivacySettings(v, settings); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.SetPrivacy(u.Id, settings)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"settings": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkHandleHandler tells whether a handle can be taken. Handles of suspended
+// and deleted users stay taken, so they can be given back on restore.
+func (app *application) checkHandleHandler(w http.ResponseWriter, r *http.Request) {
+	handle := r.URL.Query().Get("handle")
+
+	v := validator.New()
+	v.Check(handle != "", "handle", "must be provided")
+	v.Check(validator.Matches(handle, data.HandleRX), "handle", "must be 3 to 30 letters, digits or underscores")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	available := !data.IsReservedHandle(handle)
+	if available {
+		taken, err := app.models.Users.HandleTaken(handle)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		available = !taken
+	}
+
+	err := app.writeJSON(w, http.StatusOK, ResponseWrapper{"handle": handle, "available": available}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) getMyUserHandler(w http.ResponseWriter, r *http.Request) {
 	u, ok := r.Context().Value(userContextKey).(*data.User)
 	if !ok {
@@ -58,7 +259,7 @@ func (app *application) getMyUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": user.Account()}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -73,6 +274,7 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 
 	var input struct {
 		Name      *string `json:"name"`
+		Handle    *string `json:"handle"`
 		Email     *string `json:"email"`
 		AvatarUrl *string `json:"avatar"`
 	}
@@ -98,15 +300,20 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		u.Name = *input.Name
 	}
 
-	if input.Email != nil {
-		u.Email = *input.Email
+	if input.Handle != nil {
+		u.Handle = *input.Handle
 	}
 
 	if input.AvatarUrl != nil {
 		u.AvatarUrl = *input.AvatarUrl
 	}
 
+	// The email address is where activation and password reset tokens go, so an
+	// access token alone must not be enough to change it
 	v := validator.New()
+	if input.Email != nil {
+		v.Check(strings.EqualFold(*input.Email, u.Email), "email", "cannot be changed")
+	}
 	if data.ValidateUser(v, u); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -117,13 +324,111 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateHandle):
+			v.AddError("handle", "this handle is already taken")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u}, nil)
+	app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u.Account()}, nil)
+}
+
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	tokenClaims, ok := r.Context().Value(claimsContextKey).(*data.UserClaims)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing claims value"))
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetById(claims.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.confirmPassword(w, r, u, input.CurrentPassword) {
+		return
+	}
+
+	// The new session is labelled with the provider the current one logged in with
+	provider := tokenClaims.Provider
+	session, err := app.models.Sessions.Get(u.Id, tokenClaims.Family)
+	switch {
+	case err == nil:
+		provider = session.Provider
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = u.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.RevokeAllForUser(u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Every session, including the current one, has just been revoked. Issue a new
+	// token pair so that the caller stays logged in.
+	tokens, err := app.newSession(r, u, provider, tokenClaims.Scopes())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -133,13 +438,160 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err := app.models.Users.Delete(u.Id)
+	err := app.models.Users.SoftDelete(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.RevokeAllForUser(u.Id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"status": "success"}, nil)
+	restoreBy := u.DeletedAt.Add(data.UserRestoreWindow)
+
+	restoreToken, err := app.models.OneTimeTokens.New(u.Id, data.UserRestoreWindow, data.PurposeRestore)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		mailData := map[string]any{
+			"name":         u.Name,
+			"restoreToken": restoreToken.Plaintext,
+			"restoreBy":    restoreBy.UTC().Format("Jan 2, 2006"),
+		}
+
+		err := app.mailer.Send(u.Email, "account_deleted.tmpl", mailData)
+		if err != nil {
+			app.logger.Error(err, map[string]string{"user_id": strconv.Itoa(u.Id)})
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"status": "success", "restore_by": restoreBy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreUserHandler undoes the deletion of an account with the token that was
+// emailed when it was deleted. The deleted user has no session left, so the token
+// is what identifies them.
+func (app *application) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetForToken(data.PurposeRestore, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired restore token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !u.IsDeleted() {
+		v.AddError("token", "invalid or expired restore token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Restore(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.OneTimeTokens.DeleteAllForUser(data.PurposeRestore, u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	u, err := app.models.Users.GetForToken(data.PurposeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	u.Activated = true
+
+	err = app.models.Users.Update(u)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.OneTimeTokens.DeleteAllForUser(data.PurposeActivation, u.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ResponseWrapper{"user": u.Account()}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -167,7 +619,13 @@ func (app *application) searchUsersHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	users, meta, err := app.models.Users.Search(q, filters)
+	viewer, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("context missing user value"))
+		return
+	}
+
+	users, meta, err := app.models.Users.Search(q, viewer.Id, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS token_families (
+    id UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    user_id bigint NOT NULL,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+    revoked_at TIMESTAMP(0) with time zone,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    jti TEXT PRIMARY KEY NOT NULL,
+    family_id UUID NOT NULL,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) with time zone NOT NULL,
+    used_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (family_id) REFERENCES token_families(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
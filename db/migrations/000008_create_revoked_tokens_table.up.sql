@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    jti TEXT UNIQUE,
+    issued_before TIMESTAMP(0) with time zone,
+    expiry TIMESTAMP(0) with time zone NOT NULL,
+    created_at TIMESTAMP(0) with time zone DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expiry_idx ON revoked_tokens (expiry);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS one_time_tokens (
+    hash bytea PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) with time zone NOT NULL,
+    purpose TEXT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
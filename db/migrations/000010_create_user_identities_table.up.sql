@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    subject TEXT,
+    email citext NOT NULL,
+    linked_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT unique_provider_subject UNIQUE (provider, subject),
+    CONSTRAINT unique_user_provider UNIQUE (user_id, provider)
+);
+
+-- Every existing user gets an identity for the provider they signed up with. The
+-- subject of oauth identities is unknown, so it is left empty and claimed on the
+-- user's next login with that provider.
+INSERT INTO user_identities (user_id, provider, subject, email, linked_at)
+SELECT id, provider, CASE WHEN provider = 'email' THEN id::text END, email, created_at
+FROM users
+ON CONFLICT DO NOTHING;
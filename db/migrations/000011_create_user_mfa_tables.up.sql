@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id bigint PRIMARY KEY NOT NULL,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP(0) with time zone,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    hash bytea PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    used_at TIMESTAMP(0) with time zone,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
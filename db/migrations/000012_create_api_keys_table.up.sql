@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expiry TIMESTAMP(0) with time zone,
+    last_used_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT unique_user_api_key_name UNIQUE (user_id, name)
+);
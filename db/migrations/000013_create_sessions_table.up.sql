@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    family_id UUID PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+    last_seen_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (family_id) REFERENCES token_families(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Logins made before sessions were tracked are listed without device details so
+-- that they can still be ended.
+INSERT INTO sessions (family_id, user_id, provider, created_at, last_seen_at)
+SELECT id, user_id, 'unknown', created_at, created_at
+FROM token_families
+WHERE revoked_at IS NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP(0) with time zone;
+
+-- Actions are kept after the actor or the target user is deleted, so neither
+-- references users.
+CREATE TABLE IF NOT EXISTS admin_actions (
+    id bigserial PRIMARY KEY NOT NULL,
+    actor_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    action TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP(0) with time zone DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS admin_actions_user_id_idx ON admin_actions (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    archive bytea,
+    expiry TIMESTAMP(0) with time zone NOT NULL,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+    completed_at TIMESTAMP(0) with time zone,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id);
@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION users_search_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search := 
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', email), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE users SET search = 
+    setweight(to_tsvector('english', name), 'A') ||
+    setweight(to_tsvector('english', email), 'B');
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_handle_key;
+ALTER TABLE users DROP COLUMN IF EXISTS handle;
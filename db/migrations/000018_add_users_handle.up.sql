@@ -0,0 +1,28 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS handle citext;
+
+-- Existing users get their name stripped down to a handle, suffixed with their
+-- id to keep it unique
+UPDATE users SET handle = CASE
+    WHEN length(regexp_replace(name, '[^A-Za-z0-9_]', '', 'g')) >= 3
+        THEN left(lower(regexp_replace(name, '[^A-Za-z0-9_]', '', 'g')), 20) || '_' || id
+    ELSE 'user' || id
+END;
+
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_handle_key UNIQUE (handle);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_name_key;
+
+CREATE OR REPLACE FUNCTION users_search_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search := 
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', handle), 'A') ||
+        setweight(to_tsvector('english', email), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE users SET search = 
+    setweight(to_tsvector('english', name), 'A') ||
+    setweight(to_tsvector('english', handle), 'A') ||
+    setweight(to_tsvector('english', email), 'B');
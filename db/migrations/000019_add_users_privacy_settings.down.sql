@@ -0,0 +1,6 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_profile_visibility_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_visibility_check;
+
+ALTER TABLE users DROP COLUMN IF EXISTS profile_visibility;
+ALTER TABLE users DROP COLUMN IF EXISTS email_visibility;
+ALTER TABLE users DROP COLUMN IF EXISTS discoverable;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_visibility TEXT NOT NULL DEFAULT 'friends';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_visibility TEXT NOT NULL DEFAULT 'everyone';
+
+ALTER TABLE users ADD CONSTRAINT users_email_visibility_check CHECK (email_visibility IN ('nobody', 'friends', 'everyone'));
+ALTER TABLE users ADD CONSTRAINT users_profile_visibility_check CHECK (profile_visibility IN ('friends', 'everyone'));
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS friend_group_members;
+DROP TABLE IF EXISTS friend_groups;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS friend_groups (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+    updated_at TIMESTAMP(0) with time zone DEFAULT now(),
+    version INTEGER NOT NULL DEFAULT 1,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT unique_user_friend_group_name UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS friend_group_members (
+    group_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    added_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES friend_groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS friend_group_members_user_id_idx ON friend_group_members (user_id);
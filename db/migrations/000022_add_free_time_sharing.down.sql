@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS free_time_groups;
+
+ALTER TABLE free_time_viewer DROP CONSTRAINT IF EXISTS unique_free_time_viewer;
+
+ALTER TABLE free_times DROP CONSTRAINT IF EXISTS free_times_visibility_check;
+ALTER TABLE free_times ALTER COLUMN visibility DROP NOT NULL;
+ALTER TABLE free_times ALTER COLUMN visibility SET DEFAULT 'public';
+UPDATE free_times SET visibility = 'public' WHERE visibility <> 'private';
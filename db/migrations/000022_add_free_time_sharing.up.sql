@@ -0,0 +1,24 @@
+-- Public free times were only ever shown to friends
+UPDATE free_times SET visibility = 'friends' WHERE visibility = 'public' OR visibility IS NULL;
+
+ALTER TABLE free_times ALTER COLUMN visibility SET DEFAULT 'friends';
+ALTER TABLE free_times ALTER COLUMN visibility SET NOT NULL;
+ALTER TABLE free_times ADD CONSTRAINT free_times_visibility_check
+    CHECK (visibility IN ('public', 'friends', 'groups', 'specific', 'private'));
+
+DELETE FROM free_time_viewer a
+USING free_time_viewer b
+WHERE a.free_time_id = b.free_time_id AND a.user_id = b.user_id AND a.id > b.id;
+
+ALTER TABLE free_time_viewer ADD CONSTRAINT unique_free_time_viewer UNIQUE (free_time_id, user_id);
+
+CREATE TABLE IF NOT EXISTS free_time_groups (
+    free_time_id bigint NOT NULL,
+    group_id bigint NOT NULL,
+
+    PRIMARY KEY (free_time_id, group_id),
+    FOREIGN KEY (free_time_id) REFERENCES free_times(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES friend_groups(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS free_time_groups_group_id_idx ON free_time_groups (group_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS friend_invites (
+    id bigserial PRIMARY KEY NOT NULL,
+    user_id bigint NOT NULL,
+    code TEXT NOT NULL UNIQUE,
+    max_uses INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    expiry TIMESTAMP(0) with time zone NOT NULL,
+    revoked_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone DEFAULT now(),
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT friend_invites_max_uses_check CHECK (max_uses IS NULL OR max_uses > 0),
+    CONSTRAINT friend_invites_uses_check CHECK (max_uses IS NULL OR uses <= max_uses)
+);
+
+CREATE INDEX IF NOT EXISTS friend_invites_user_id_idx ON friend_invites (user_id);
@@ -0,0 +1 @@
+ALTER TABLE revoked_tokens ALTER COLUMN issued_before TYPE TIMESTAMP(0) with time zone;
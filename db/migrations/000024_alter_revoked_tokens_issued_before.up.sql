@@ -0,0 +1 @@
+ALTER TABLE revoked_tokens ALTER COLUMN issued_before TYPE TIMESTAMP(6) with time zone;
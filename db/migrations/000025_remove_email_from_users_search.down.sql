@@ -0,0 +1,14 @@
+CREATE OR REPLACE FUNCTION users_search_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search := 
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', handle), 'A') ||
+        setweight(to_tsvector('english', email), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE users SET search = 
+    setweight(to_tsvector('english', name), 'A') ||
+    setweight(to_tsvector('english', handle), 'A') ||
+    setweight(to_tsvector('english', email), 'B');
@@ -0,0 +1,13 @@
+-- Email addresses are matched exactly, and only where they are visible, instead
+CREATE OR REPLACE FUNCTION users_search_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search := 
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', handle), 'A');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE users SET search = 
+    setweight(to_tsvector('english', name), 'A') ||
+    setweight(to_tsvector('english', handle), 'A');
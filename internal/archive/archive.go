@@ -0,0 +1,59 @@
+// Package archive writes zip archives that hold the same data both as a JSON
+// document and as CSV files, one per table, for people who'd rather open it in a
+// spreadsheet.
+package archive
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Table is written to the archive as the CSV file csv/<Name>.csv.
+type Table struct {
+	Name   string
+	Header []string
+	Rows   [][]string
+}
+
+// Write writes a zip archive to w holding v encoded as JSON in <name>.json, and
+// each of the tables as a CSV file.
+func Write(w io.Writer, name string, v any, tables []Table) error {
+	zw := zip.NewWriter(w)
+	modified := time.Now()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name + ".json", Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: "csv/" + table.Name + ".csv", Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+
+		cw := csv.NewWriter(f)
+
+		err = cw.Write(table.Header)
+		if err != nil {
+			return err
+		}
+
+		err = cw.WriteAll(table.Rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
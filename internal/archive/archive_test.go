@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	v := map[string]any{"name": "Alice"}
+	tables := []Table{
+		{
+			Name:   "friends",
+			Header: []string{"id", "name"},
+			Rows:   [][]string{{"2", "Bob"}, {"3", "Carol, \"Caz\""}},
+		},
+		{
+			Name:   "free_times",
+			Header: []string{"id", "start_time"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+
+	err := Write(buf, "export", v, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	if len(files) != 3 {
+		t.Fatalf("got %d files; want 3", len(files))
+	}
+
+	f, ok := files["export.json"]
+	if !ok {
+		t.Fatal("missing export.json")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	var got map[string]any
+	err = json.NewDecoder(rc).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("export.json: got %v; want %v", got, v)
+	}
+
+	for _, table := range tables {
+		f, ok := files["csv/"+table.Name+".csv"]
+		if !ok {
+			t.Fatalf("missing csv/%s.csv", table.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := csv.NewReader(rc).ReadAll()
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := append([][]string{table.Header}, table.Rows...)
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("csv/%s.csv: got %q; want %q", table.Name, records, want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidState is returned when an authorization response can't be matched to a
+// login started by the same browser, or the login has already been completed.
+var ErrInvalidState = errors.New("auth: invalid or expired oauth state")
+
+const flowCookieName = "materix_oauth"
+
+// AuthRequest holds the parameters generated for a single login attempt.
+type AuthRequest struct {
+	Provider     string `json:"p"`
+	State        string `json:"s"`
+	CodeVerifier string `json:"v"`
+	// Nonce binds an OpenID Connect id token to the login it was requested for.
+	Nonce  string `json:"n"`
+	Expiry int64  `json:"e"`
+	// LinkUserId is set when an authenticated user is linking the provider to
+	// their account rather than logging in.
+	LinkUserId int `json:"l,omitempty"`
+	// Scope is passed through to the tokens issued once the login completes.
+	Scope string `json:"sc,omitempty"`
+	// Invite is the code of a friend invite to redeem if the login signs up a
+	// new user.
+	Invite string `json:"i,omitempty"`
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for the request's verifier.
+func (a *AuthRequest) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(a.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// FlowManager generates a random state and PKCE verifier for every login and binds
+// them to the browser with a signed, short-lived cookie. A state is accepted on the
+// callback only once.
+type FlowManager struct {
+	key    []byte
+	ttl    time.Duration
+	secure bool
+	now    func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func NewFlowManager(key []byte, secureCookies bool) *FlowManager {
+	return &FlowManager{
+		key:    key,
+		ttl:    10 * time.Minute,
+		secure: secureCookies,
+		now:    time.Now,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Begin starts a login with the named provider and sets the cookie that the
+// callback is verified against. The scope and invite are returned unchanged by
+// Complete.
+func (m *FlowManager) Begin(w http.ResponseWriter, provider, scope, invite string) (*AuthRequest, error) {
+	return m.begin(w, provider, scope, invite, 0)
+}
+
+// BeginLink starts linking the named provider to the account of an authenticated
+// user.
+func (m *FlowManager) BeginLink(w http.ResponseWriter, provider string, userId int) (*AuthRequest, error) {
+	return m.begin(w, provider, "", "", userId)
+}
+
+func (m *FlowManager) begin(w http.ResponseWriter, provider, scope, invite string, linkUserId int) (*AuthRequest, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomString(48)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &AuthRequest{
+		Provider:     provider,
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		Expiry:       m.now().Add(m.ttl).Unix(),
+		LinkUserId:   linkUserId,
+		Scope:        scope,
+		Invite:       invite,
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	value = value + "." + base64.RawURLEncoding.EncodeToString(m.sign(value))
+
+	http.SetCookie(w, m.cookie(value, int(m.ttl.Seconds())))
+
+	return req, nil
+}
+
+// Complete verifies the state returned to the callback of the named provider
+// against the browser's cookie and returns the matching request. The cookie is
+// cleared and the state can't be used again.
+func (m *FlowManager) Complete(w http.ResponseWriter, r *http.Request, provider string) (*AuthRequest, error) {
+	http.SetCookie(w, m.cookie("", -1))
+
+	c, err := r.Cookie(flowCookieName)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	value, signature, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return nil, ErrInvalidState
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, m.sign(value)) {
+		return nil, ErrInvalidState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	var req AuthRequest
+	err = json.Unmarshal(payload, &req)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	state := r.URL.Query().Get("state")
+	if req.Provider != provider || subtle.ConstantTimeCompare([]byte(req.State), []byte(state)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	expiry := time.Unix(req.Expiry, 0)
+	if !m.now().Before(expiry) {
+		return nil, ErrInvalidState
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for s, exp := range m.used {
+		if !m.now().Before(exp) {
+			delete(m.used, s)
+		}
+	}
+
+	if _, ok := m.used[req.State]; ok {
+		return nil, ErrInvalidState
+	}
+	m.used[req.State] = expiry
+
+	return &req, nil
+}
+
+func (m *FlowManager) sign(value string) []byte {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+func (m *FlowManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     flowCookieName,
+		Value:    value,
+		Path:     "/api/auth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
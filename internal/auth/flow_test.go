@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	t.Parallel()
+
+	// Example from RFC 7636, appendix B
+	req := &AuthRequest{CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}
+
+	if req.CodeChallenge() != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unexpected code challenge %s", req.CodeChallenge())
+	}
+}
+
+// beginFlow starts a login and returns the callback request that the browser would
+// make with the given state.
+func beginFlow(t *testing.T, m *FlowManager, provider string) (*AuthRequest, *http.Cookie) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	req, err := m.Begin(rr, provider, "freetimes:read", "abcdefghijklmnop")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected 1 cookie, but got %d", len(cookies))
+	}
+
+	if !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Error("expected an http only, same site lax cookie")
+	}
+
+	return req, cookies[0]
+}
+
+func callback(cookie *http.Cookie, state string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/google/callback?code=abc&state="+state, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestFlowManager(t *testing.T) {
+	t.Parallel()
+
+	m := NewFlowManager([]byte("secret"), true)
+
+	req, cookie := beginFlow(t, m, "google")
+
+	if req.State == "" || req.CodeVerifier == "" {
+		t.Fatal("expected state and code verifier to be generated")
+	}
+
+	got, err := m.Complete(httptest.NewRecorder(), callback(cookie, req.State), "google")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.CodeVerifier != req.CodeVerifier {
+		t.Errorf("expected code verifier %s, but got %s", req.CodeVerifier, got.CodeVerifier)
+	}
+
+	if got.Scope != "freetimes:read" {
+		t.Errorf("expected scope freetimes:read, but got %q", got.Scope)
+	}
+
+	if got.Invite != "abcdefghijklmnop" {
+		t.Errorf("expected invite abcdefghijklmnop, but got %q", got.Invite)
+	}
+
+	_, err = m.Complete(httptest.NewRecorder(), callback(cookie, req.State), "google")
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected reused state to be rejected, but got %v", err)
+	}
+}
+
+func TestFlowManagerLink(t *testing.T) {
+	t.Parallel()
+
+	m := NewFlowManager([]byte("secret"), true)
+
+	rr := httptest.NewRecorder()
+	req, err := m.BeginLink(rr, "google", 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := m.Complete(httptest.NewRecorder(), callback(rr.Result().Cookies()[0], req.State), "google")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.LinkUserId != 42 {
+		t.Errorf("expected link user id 42, but got %d", got.LinkUserId)
+	}
+}
+
+func TestFlowManagerRejects(t *testing.T) {
+	t.Parallel()
+
+	m := NewFlowManager([]byte("secret"), true)
+	other := NewFlowManager([]byte("other-secret"), true)
+
+	tests := []struct {
+		name     string
+		callback func() *http.Request
+		provider string
+	}{
+		{"missing cookie", func() *http.Request {
+			req, _ := beginFlow(t, m, "google")
+			return callback(nil, req.State)
+		}, "google"},
+		{"wrong state", func() *http.Request {
+			_, cookie := beginFlow(t, m, "google")
+			return callback(cookie, "forged")
+		}, "google"},
+		{"wrong provider", func() *http.Request {
+			req, cookie := beginFlow(t, m, "google")
+			return callback(cookie, req.State)
+		}, "github"},
+		{"foreign signature", func() *http.Request {
+			req, cookie := beginFlow(t, other, "google")
+			return callback(cookie, req.State)
+		}, "google"},
+		{"tampered cookie", func() *http.Request {
+			req, cookie := beginFlow(t, m, "google")
+			cookie.Value = "e30" + cookie.Value[3:]
+			return callback(cookie, req.State)
+		}, "google"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Complete(httptest.NewRecorder(), tt.callback(), tt.provider)
+			if !errors.Is(err, ErrInvalidState) {
+				t.Errorf("expected ErrInvalidState, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestFlowManagerExpiry(t *testing.T) {
+	t.Parallel()
+
+	m := NewFlowManager([]byte("secret"), false)
+	req, cookie := beginFlow(t, m, "google")
+
+	m.now = func() time.Time { return time.Now().Add(11 * time.Minute) }
+
+	_, err := m.Complete(httptest.NewRecorder(), callback(cookie, req.State), "google")
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected expired state to be rejected, but got %v", err)
+	}
+}
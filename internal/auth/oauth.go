@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrProviderRejected is returned when a provider answers a request with a non-2xx
+// status, e.g. because an authorization code is invalid or has already been used.
+var ErrProviderRejected = errors.New("auth: request rejected by provider")
+
+// Identity is the profile of a user as reported by an OAuth provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AvatarUrl     string
+	// Username is the user's handle at the provider, if it has one.
+	Username string
+}
+
+// Token is the response of a provider's token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token,omitempty"`
+
+	// claims holds the id token claims once they have been verified.
+	claims *idTokenClaims
+}
+
+// OAuthProvider is an OAuth 2.0 authorization server that users can log in with.
+type OAuthProvider interface {
+	// Name identifies the provider in routes, e.g. /api/auth/{name}/login.
+	Name() string
+	// AuthCodeURL returns the url that the user is sent to in order to log in.
+	AuthCodeURL(req *AuthRequest) string
+	// Exchange trades an authorization code obtained for req for a token.
+	Exchange(ctx context.Context, code string, req *AuthRequest) (*Token, error)
+	// Identity fetches the profile of the user that the token was issued to.
+	Identity(ctx context.Context, token *Token) (*Identity, error)
+}
+
+// Registry holds the OAuth providers that are configured for the application.
+type Registry struct {
+	providers map[string]OAuthProvider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]OAuthProvider)}
+}
+
+// Register adds a provider to the registry, replacing any provider with the same
+// name. It is not safe to call once the registry is in use.
+func (r *Registry) Register(p OAuthProvider) {
+	r.providers[p.Name()] = p
+}
+
+func (r *Registry) Get(name string) (OAuthProvider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the names of the registered providers in alphabetical order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Config describes an OAuth 2.0 client registered with a provider.
+type Config struct {
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+}
+
+// identityMapper turns the body of a provider's user info response into an Identity.
+type identityMapper func(ctx context.Context, p *OAuth2Provider, token *Token, body []byte) (*Identity, error)
+
+// OAuth2Provider is an OAuth 2.0 provider that exposes a JSON user info endpoint.
+type OAuth2Provider struct {
+	name        string
+	config      Config
+	client      *http.Client
+	mapIdentity identityMapper
+}
+
+func newOAuth2Provider(name string, config Config, defaults Config, mapIdentity identityMapper) *OAuth2Provider {
+	if config.AuthURL == "" {
+		config.AuthURL = defaults.AuthURL
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = defaults.TokenURL
+	}
+	if config.UserInfoURL == "" {
+		config.UserInfoURL = defaults.UserInfoURL
+	}
+	if config.Scopes == nil {
+		config.Scopes = defaults.Scopes
+	}
+
+	return &OAuth2Provider{
+		name:        name,
+		config:      config,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		mapIdentity: mapIdentity,
+	}
+}
+
+func (p *OAuth2Provider) Name() string {
+	return p.name
+}
+
+func (p *OAuth2Provider) AuthCodeURL(req *AuthRequest) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientId},
+		"redirect_uri":          {p.config.RedirectURL},
+		"state":                 {req.State},
+		"code_challenge":        {req.CodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+	if len(p.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(p.config.Scopes, " "))
+	}
+
+	sep := "?"
+	if strings.Contains(p.config.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.config.AuthURL + sep + params.Encode()
+}
+
+func (p *OAuth2Provider) Exchange(ctx context.Context, code string, authReq *AuthRequest) (*Token, error) {
+	return exchange(ctx, p.client, p.name, p.config, code, authReq)
+}
+
+func (p *OAuth2Provider) Identity(ctx context.Context, token *Token) (*Identity, error) {
+	body, err := p.get(ctx, p.config.UserInfoURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := p.mapIdentity(ctx, p, token, body)
+	if err != nil {
+		return nil, err
+	}
+	identity.Provider = p.name
+
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("auth: %s user info has no subject", p.name)
+	}
+
+	return identity, nil
+}
+
+func (p *OAuth2Provider) get(ctx context.Context, endpoint string, token *Token) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return do(p.client, req)
+}
+
+// exchange trades an authorization code for a token at the token endpoint of config.
+func exchange(ctx context.Context, client *http.Client, name string, config Config, code string, authReq *AuthRequest) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.RedirectURL},
+		"client_id":     {config.ClientId},
+		"client_secret": {config.ClientSecret},
+		"code_verifier": {authReq.CodeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	body, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return nil, fmt.Errorf("auth: decoding %s token response: %w", name, err)
+	}
+
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: %s token response has no access token", ErrProviderRejected, name)
+	}
+
+	return &token, nil
+}
+
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: %s %s returned %s", ErrProviderRejected, req.Method, req.URL.Redacted(), res.Status)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+var testAuthRequest = &AuthRequest{
+	Provider:     "test",
+	State:        "xyz",
+	CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+}
+
+// newTestIdP starts a stand-in identity provider which issues "access-token" for
+// the authorization code "good-code" and serves the given user info documents.
+func newTestIdP(t *testing.T, userInfo map[string]any) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("client_secret") != "secret" ||
+			r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code_verifier") != testAuthRequest.CodeVerifier {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token", "token_type": "Bearer"})
+	})
+
+	for path, body := range userInfo {
+		body := body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer access-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(body)
+		})
+	}
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func testConfig(srv *httptest.Server, userInfoPath string) Config {
+	return Config{
+		ClientId:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://materix.app/api/auth/test/callback",
+		AuthURL:      srv.URL + "/authorize",
+		TokenURL:     srv.URL + "/token",
+		UserInfoURL:  srv.URL + userInfoPath,
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	r.Register(NewGoogle(Config{}))
+	r.Register(NewGitHub(Config{}))
+
+	if _, ok := r.Get("google"); !ok {
+		t.Error("expected google to be registered")
+	}
+
+	if _, ok := r.Get("gitlab"); ok {
+		t.Error("expected gitlab not to be registered")
+	}
+
+	names := r.Names()
+	if len(names) != 2 || names[0] != "github" || names[1] != "google" {
+		t.Errorf("expected [github google], but got %v", names)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	t.Parallel()
+
+	p := NewGoogle(Config{ClientId: "client", RedirectURL: "https://materix.app/api/auth/google/callback"})
+
+	u, err := url.Parse(p.AuthCodeURL(testAuthRequest))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if u.Host != "accounts.google.com" {
+		t.Errorf("expected default google auth url, but got %s", u.Host)
+	}
+
+	q := u.Query()
+	expected := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "https://materix.app/api/auth/google/callback",
+		"state":                 "xyz",
+		"scope":                 "openid email profile",
+		"code_challenge":        "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range expected {
+		if q.Get(key) != value {
+			t.Errorf("expected %s to be %q, but got %q", key, value, q.Get(key))
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestIdP(t, nil)
+	p := NewGoogle(testConfig(srv, "/userinfo"))
+
+	token, err := p.Exchange(context.Background(), "good-code", testAuthRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if token.AccessToken != "access-token" {
+		t.Errorf("expected access token to be access-token, but got %s", token.AccessToken)
+	}
+
+	_, err = p.Exchange(context.Background(), "bad-code", testAuthRequest)
+	if !errors.Is(err, ErrProviderRejected) {
+		t.Errorf("expected ErrProviderRejected, but got %v", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestIdP(t, map[string]any{
+		"/userinfo": map[string]any{
+			"sub":            "1234",
+			"email":          "jane@example.com",
+			"email_verified": true,
+			"given_name":     "Jane",
+			"family_name":    "Doe",
+			"picture":        "https://example.com/jane.png",
+		},
+		"/user": map[string]any{
+			"id":         42,
+			"login":      "jdoe",
+			"avatar_url": "https://example.com/jdoe.png",
+		},
+		"/user/emails": []map[string]any{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "jdoe@example.com", "primary": true, "verified": true},
+		},
+		"/api/v4/user": map[string]any{
+			"id":           7,
+			"username":     "jdoe",
+			"name":         "John Doe",
+			"email":        "john@example.com",
+			"confirmed_at": "2024-01-01T00:00:00Z",
+		},
+	})
+
+	tests := []struct {
+		provider OAuthProvider
+		expected Identity
+	}{
+		{
+			NewGoogle(testConfig(srv, "/userinfo")),
+			Identity{"google", "1234", "jane@example.com", true, "Jane Doe", "https://example.com/jane.png", ""},
+		},
+		{
+			NewMicrosoft(testConfig(srv, "/userinfo")),
+			Identity{"microsoft", "1234", "jane@example.com", true, "Jane Doe", "https://example.com/jane.png", ""},
+		},
+		{
+			NewGitHub(testConfig(srv, "/user")),
+			Identity{"github", "42", "jdoe@example.com", true, "jdoe", "https://example.com/jdoe.png", "jdoe"},
+		},
+		{
+			NewGitLab(testConfig(srv, "/api/v4/user")),
+			Identity{"gitlab", "7", "john@example.com", true, "John Doe", "", "jdoe"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider.Name(), func(t *testing.T) {
+			token, err := tt.provider.Exchange(context.Background(), "good-code", testAuthRequest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			identity, err := tt.provider.Identity(context.Background(), token)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if *identity != tt.expected {
+				t.Errorf("expected %+v, but got %+v", tt.expected, *identity)
+			}
+		})
+	}
+}
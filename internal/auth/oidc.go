@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrEmailNotVerified is returned when an OpenID Connect provider doesn't vouch for
+// the email address of the user that logged in.
+var ErrEmailNotVerified = errors.New("auth: email address not verified by provider")
+
+// jwksRefreshInterval limits how often the signing keys are refetched when an id
+// token is signed with a key that isn't known yet.
+const jwksRefreshInterval = time.Minute
+
+// OIDCProvider is a generic OpenID Connect provider. Its endpoints are discovered
+// from the issuer and the user's identity is taken from the id token, whose
+// signature and claims are verified against the issuer's published keys.
+type OIDCProvider struct {
+	name     string
+	issuer   string
+	config   Config
+	client   *http.Client
+	jwksURL  string
+	now      func() time.Time
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+}
+
+// NewOIDC discovers the endpoints of the issuer from its
+// /.well-known/openid-configuration document. Endpoints set in config take
+// precedence over discovered ones.
+func NewOIDC(ctx context.Context, name, issuer string, config Config) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		name:   name,
+		issuer: strings.TrimSuffix(issuer, "/"),
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := do(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksURI               string `json:"jwks_uri"`
+	}
+
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("auth: decoding %s discovery document: %w", name, err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("auth: %s discovery document issuer %q does not match %q", name, doc.Issuer, issuer)
+	}
+
+	if p.config.AuthURL == "" {
+		p.config.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.config.TokenURL == "" {
+		p.config.TokenURL = doc.TokenEndpoint
+	}
+	if p.config.Scopes == nil {
+		p.config.Scopes = []string{"openid", "email", "profile"}
+	}
+	p.jwksURL = doc.JwksURI
+
+	if p.config.AuthURL == "" || p.config.TokenURL == "" || p.jwksURL == "" {
+		return nil, fmt.Errorf("auth: %s discovery document is missing endpoints", name)
+	}
+
+	return p, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(req *AuthRequest) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientId},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {req.CodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.config.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.config.AuthURL + sep + params.Encode()
+}
+
+// Exchange trades the code for tokens and verifies the id token that comes with
+// them. The verified claims are kept on the token for Identity.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, authReq *AuthRequest) (*Token, error) {
+	token, err := exchange(ctx, p.client, p.name, p.config, code, authReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: %s token response has no id token", ErrProviderRejected, p.name)
+	}
+
+	claims, err := p.verify(ctx, token.IDToken, authReq.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	token.claims = claims
+
+	return token, nil
+}
+
+func (p *OIDCProvider) Identity(ctx context.Context, token *Token) (*Identity, error) {
+	claims := token.claims
+	if claims == nil {
+		return nil, fmt.Errorf("auth: %s token has no verified id token", p.name)
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, ErrEmailNotVerified
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+
+	return &Identity{
+		Provider:      p.name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: true,
+		Name:          name,
+		AvatarUrl:     claims.Picture,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+type idTokenClaims struct {
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Picture       string   `json:"picture"`
+	// PreferredUsername is only a hint, it isn't unique or stable
+	PreferredUsername string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// flexBool accepts both JSON booleans and the strings "true" and "false", which
+// some providers use for email_verified.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("auth: invalid boolean %s", data)
+	}
+	return nil
+}
+
+func (p *OIDCProvider) verify(ctx context.Context, rawIDToken, nonce string) (*idTokenClaims, error) {
+	var claims idTokenClaims
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.config.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(p.now),
+		jwt.WithLeeway(30*time.Second),
+	)
+
+	_, err := parser.ParseWithClaims(rawIDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s id token: %v", ErrProviderRejected, p.name, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: %s id token has no subject", ErrProviderRejected, p.name)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: %s id token nonce mismatch", ErrProviderRejected, p.name)
+	}
+
+	return &claims, nil
+}
+
+// key returns the issuer's public key with the given id, refetching the key set
+// when the key is unknown since the issuer may have rotated its keys.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	if p.keys != nil && p.now().Sub(p.loadedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("auth: unknown %s signing key %q", p.name, kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.loadedAt = p.now()
+
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("auth: unknown %s signing key %q", p.name, kid)
+}
+
+func (p *OIDCProvider) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := do(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return nil, fmt.Errorf("auth: decoding %s key set: %w", p.name, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// Skip keys of unsupported types rather than rejecting the whole set
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("auth: rsa exponent too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("auth: unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("auth: ec point is not on curve")
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("auth: unsupported key type %q", k.Kty)
+}
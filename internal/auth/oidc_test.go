@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testOIDCIdP is a stand-in OpenID Connect provider which publishes an RSA and an
+// EC signing key and answers the token endpoint with whatever id token is set.
+type testOIDCIdP struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newTestOIDCIdP(t *testing.T) *testOIDCIdP {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testOIDCIdP{rsaKey: rsaKey, ecKey: ecKey}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "use": "sig", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "hmac-1", "k": "c2VjcmV0"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("code_verifier") != testAuthRequest.CodeVerifier {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token", "token_type": "Bearer", "id_token": idp.idToken})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// claims returns valid id token claims for testOIDCRequest.
+func (idp *testOIDCIdP) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            "client",
+		"sub":            "248289761001",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          testOIDCRequest.Nonce,
+		"email":          "jane@corp.example",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+func (idp *testOIDCIdP) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	var key any = idp.rsaKey
+	if method == jwt.SigningMethodES256 {
+		key = idp.ecKey
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mu.Lock()
+	idp.idToken = signed
+	idp.mu.Unlock()
+}
+
+var testOIDCRequest = &AuthRequest{
+	Provider:     "corp",
+	State:        "xyz",
+	CodeVerifier: testAuthRequest.CodeVerifier,
+	Nonce:        "n-0S6_WzA2Mj",
+}
+
+func newTestOIDC(t *testing.T, idp *testOIDCIdP) *OIDCProvider {
+	t.Helper()
+
+	p, err := NewOIDC(context.Background(), "corp", idp.URL, Config{
+		ClientId:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://materix.app/api/auth/corp/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	t.Parallel()
+
+	idp := newTestOIDCIdP(t)
+	p := newTestOIDC(t, idp)
+
+	u, err := url.Parse(p.AuthCodeURL(testOIDCRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.URL+"/authorize" {
+		t.Errorf("got authorization endpoint %q", got)
+	}
+
+	q := u.Query()
+	if q.Get("nonce") != testOIDCRequest.Nonce {
+		t.Errorf("got nonce %q; want %q", q.Get("nonce"), testOIDCRequest.Nonce)
+	}
+	if q.Get("scope") != "openid email profile" {
+		t.Errorf("got scope %q", q.Get("scope"))
+	}
+
+	_, err = NewOIDC(context.Background(), "corp", idp.URL+"/other", Config{})
+	if err == nil {
+		t.Error("expected an error for an issuer without a discovery document")
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	idp := newTestOIDCIdP(t)
+	p := newTestOIDC(t, idp)
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		kid     string
+		modify  func(jwt.MapClaims)
+		wantErr error
+	}{
+		{name: "RS256", method: jwt.SigningMethodRS256, kid: "rsa-1"},
+		{name: "ES256", method: jwt.SigningMethodES256, kid: "ec-1"},
+		{name: "String email_verified", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["email_verified"] = "true" }},
+		{name: "Wrong nonce", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }, wantErr: ErrProviderRejected},
+		{name: "Missing nonce", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: ErrProviderRejected},
+		{name: "Wrong audience", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, wantErr: ErrProviderRejected},
+		{name: "Wrong issuer", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, wantErr: ErrProviderRejected},
+		{name: "Expired", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: ErrProviderRejected},
+		{name: "Missing expiry", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: ErrProviderRejected},
+		{name: "Wrong key", method: jwt.SigningMethodES256, kid: "rsa-1", wantErr: ErrProviderRejected},
+		{name: "Unknown key", method: jwt.SigningMethodRS256, kid: "rsa-2", wantErr: ErrProviderRejected},
+		{name: "Email not verified", method: jwt.SigningMethodRS256, kid: "rsa-1", modify: func(c jwt.MapClaims) { c["email_verified"] = false }, wantErr: ErrEmailNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idp.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			idp.sign(t, tt.method, tt.kid, claims)
+
+			token, err := p.Exchange(context.Background(), "good-code", testOIDCRequest)
+			var identity *Identity
+			if err == nil {
+				identity, err = p.Identity(context.Background(), token)
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Identity{Provider: "corp", Subject: "248289761001", Email: "jane@corp.example", EmailVerified: true, Name: "Jane Doe"}
+			if *identity != want {
+				t.Errorf("got %+v; want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestOIDCIdentityRequiresVerifiedToken(t *testing.T) {
+	t.Parallel()
+
+	idp := newTestOIDCIdP(t)
+	p := newTestOIDC(t, idp)
+
+	_, err := p.Identity(context.Background(), &Token{AccessToken: "access-token", IDToken: "forged"})
+	if err == nil {
+		t.Error("expected an error for a token that wasn't obtained through Exchange")
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+func NewGoogle(config Config) *OAuth2Provider {
+	return newOAuth2Provider("google", config, Config{
+		AuthURL:     "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:    "https://oauth2.googleapis.com/token",
+		UserInfoURL: "https://openidconnect.googleapis.com/v1/userinfo",
+		Scopes:      []string{"openid", "email", "profile"},
+	}, mapStandardClaims)
+}
+
+func NewMicrosoft(config Config) *OAuth2Provider {
+	return newOAuth2Provider("microsoft", config, Config{
+		AuthURL:     "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		TokenURL:    "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		UserInfoURL: "https://graph.microsoft.com/oidc/userinfo",
+		Scopes:      []string{"openid", "email", "profile"},
+	}, mapStandardClaims)
+}
+
+func NewGitHub(config Config) *OAuth2Provider {
+	return newOAuth2Provider("github", config, Config{
+		AuthURL:     "https://github.com/login/oauth/authorize",
+		TokenURL:    "https://github.com/login/oauth/access_token",
+		UserInfoURL: "https://api.github.com/user",
+		Scopes:      []string{"read:user", "user:email"},
+	}, mapGitHubUser)
+}
+
+func NewGitLab(config Config) *OAuth2Provider {
+	return newOAuth2Provider("gitlab", config, Config{
+		AuthURL:     "https://gitlab.com/oauth/authorize",
+		TokenURL:    "https://gitlab.com/oauth/token",
+		UserInfoURL: "https://gitlab.com/api/v4/user",
+		Scopes:      []string{"read_user"},
+	}, mapGitLabUser)
+}
+
+// mapStandardClaims maps a user info response made of standard OpenID Connect claims.
+func mapStandardClaims(ctx context.Context, p *OAuth2Provider, token *Token, body []byte) (*Identity, error) {
+	var claims struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+		Name          string `json:"name"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+		Picture       string `json:"picture"`
+	}
+
+	err := json.Unmarshal(body, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+
+	return &Identity{
+		Subject:       claims.Sub,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Name:          name,
+		AvatarUrl:     claims.Picture,
+	}, nil
+}
+
+func mapGitHubUser(ctx context.Context, p *OAuth2Provider, token *Token, body []byte) (*Identity, error) {
+	var user struct {
+		Id        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarUrl string `json:"avatar_url"`
+	}
+
+	err := json.Unmarshal(body, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject:   strconv.FormatInt(user.Id, 10),
+		Email:     user.Email,
+		Name:      user.Name,
+		AvatarUrl: user.AvatarUrl,
+		Username:  user.Login,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	// The public profile email is optional, so ask for the primary address instead,
+	// which also tells us whether it has been verified.
+	emailsUrl := strings.TrimSuffix(p.config.UserInfoURL, "/") + "/emails"
+	emailsBody, err := p.get(ctx, emailsUrl, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	err = json.Unmarshal(emailsBody, &emails)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+
+	if identity.Email == "" {
+		return nil, errors.New("auth: failed to fetch github user email")
+	}
+
+	return identity, nil
+}
+
+func mapGitLabUser(ctx context.Context, p *OAuth2Provider, token *Token, body []byte) (*Identity, error) {
+	var user struct {
+		Id          int64   `json:"id"`
+		Username    string  `json:"username"`
+		Name        string  `json:"name"`
+		Email       string  `json:"email"`
+		AvatarUrl   string  `json:"avatar_url"`
+		ConfirmedAt *string `json:"confirmed_at"`
+	}
+
+	err := json.Unmarshal(body, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject:       strconv.FormatInt(user.Id, 10),
+		Email:         user.Email,
+		EmailVerified: user.ConfirmedAt != nil,
+		Name:          user.Name,
+		AvatarUrl:     user.AvatarUrl,
+		Username:      user.Username,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Username
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,56 @@
+// Package blob stores files, such as uploaded images, under slash separated keys
+// either on the local filesystem or in an S3 compatible bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Object is a stored blob.
+type Object struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// Store keeps blobs under keys.
+type Store interface {
+	// Put stores data under key, replacing anything already stored there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob stored under the directory dir, i.e. whose
+	// key starts with dir followed by a slash.
+	DeletePrefix(ctx context.Context, dir string) error
+}
+
+var keySegmentRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidKey reports whether key can be stored. Keys are made of slash separated
+// segments of letters, digits, dots, dashes and underscores, none of which may
+// start with a dot, so a key can never escape the store it is used with.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if !keySegmentRX.MatchString(segment) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,232 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "avatars/1/256.jpg", want: true},
+		{key: "a-b_c.d", want: true},
+		{key: "", want: false},
+		{key: "/avatars/1.jpg", want: false},
+		{key: "avatars//1.jpg", want: false},
+		{key: "avatars/../1.jpg", want: false},
+		{key: "avatars/.hidden", want: false},
+		{key: `avatars\1.jpg`, want: false},
+		{key: "avatars/1.jpg?x=1", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidKey(tt.key); got != tt.want {
+			t.Errorf("ValidKey(%q): got %t; want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	testStore(t, NewFileStore(t.TempDir()))
+}
+
+func TestS3Store(t *testing.T) {
+	t.Parallel()
+
+	bucket := newFakeS3(t, "avatars-bucket", "AKID")
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+
+	testStore(t, NewS3Store(S3Config{
+		Endpoint:        srv.URL + "/",
+		Region:          "us-east-1",
+		Bucket:          "avatars-bucket",
+		AccessKeyId:     "AKID",
+		SecretAccessKey: "secret",
+	}))
+}
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	data := []byte("\x89PNG not really")
+
+	_, err := s.Get(ctx, "avatars/1/64.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get missing: got %v; want ErrNotFound", err)
+	}
+
+	err = s.Put(ctx, "avatars/1/64.png", data, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := s.Get(ctx, "avatars/1/64.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(obj.Data, data) {
+		t.Errorf("Get: got %q; want %q", obj.Data, data)
+	}
+	if obj.ContentType != "image/png" {
+		t.Errorf("Get: got content type %q; want image/png", obj.ContentType)
+	}
+
+	err = s.Delete(ctx, "avatars/1/64.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Get(ctx, "avatars/1/64.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get deleted: got %v; want ErrNotFound", err)
+	}
+
+	err = s.Delete(ctx, "avatars/1/64.png")
+	if err != nil {
+		t.Errorf("Delete missing: got %v; want nil", err)
+	}
+
+	err = s.Put(ctx, "../escape.png", data, "image/png")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put invalid key: got %v; want ErrInvalidKey", err)
+	}
+
+	deleted := []string{"avatars/1/a/64.jpg", "avatars/1/a/128.jpg", "avatars/1/b/64.jpg"}
+	kept := []string{"avatars/10/a/64.jpg", "avatars/2/a/64.jpg"}
+	for _, key := range append(deleted, kept...) {
+		err = s.Put(ctx, key, data, "image/jpeg")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.DeletePrefix(ctx, "avatars/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range deleted {
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get %s after DeletePrefix: got %v; want ErrNotFound", key, err)
+		}
+	}
+	for _, key := range kept {
+		if _, err := s.Get(ctx, key); err != nil {
+			t.Errorf("Get %s after DeletePrefix: got %v; want it kept", key, err)
+		}
+	}
+
+	err = s.DeletePrefix(ctx, "avatars/1")
+	if err != nil {
+		t.Errorf("DeletePrefix missing: got %v; want nil", err)
+	}
+}
+
+// fakeS3 stands in for an S3 compatible service, keeping a single bucket in
+// memory and rejecting requests that aren't signed with its access key.
+type fakeS3 struct {
+	t           *testing.T
+	bucket      string
+	accessKeyId string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T, bucket, accessKeyId string) *fakeS3 {
+	return &fakeS3{t: t, bucket: bucket, accessKeyId: accessKeyId, objects: make(map[string]fakeObject)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential="+f.accessKeyId+"/") ||
+		!strings.Contains(authorization, "/us-east-1/s3/aws4_request, SignedHeaders=") ||
+		r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		f.t.Errorf("%s %s: unsigned request, Authorization: %q", r.Method, r.URL.Path, authorization)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodGet && r.URL.Path == "/"+f.bucket && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Write(obj.data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers a ListObjectsV2 request two keys at a time, so that clients have
+// to follow continuation tokens.
+func (f *fakeS3) list(w http.ResponseWriter, prefix, after string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	truncated := len(keys) > 2
+	if truncated {
+		keys = keys[:2]
+	}
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><IsTruncated>%t</IsTruncated>`, truncated)
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+	}
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
@@ -0,0 +1,85 @@
+// Package cache keeps values in process for a limited time, for results that are
+// expensive to compute and fine to serve slightly stale.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key    K
+	value  V
+	expiry time.Time
+}
+
+// Cache maps keys to values that expire a fixed time after they are set. It holds
+// at most a fixed number of entries, dropping the oldest to make room for new
+// ones. Expired entries are pruned as new ones are set.
+type Cache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[K]*list.Element
+	// order holds the entries oldest first. Since every entry lives for the same
+	// time, that is also the order they expire in.
+	order *list.List
+	now   func() time.Time
+}
+
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[K]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get returns the value set for key, unless it expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok || !c.now().Before(el.Value.(*entry[K, V]).expiry) {
+		var zero V
+		return zero, false
+	}
+
+	return el.Value.(*entry[K, V]).value, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushBack(&entry[K, V]{key: key, value: value, expiry: now.Add(c.ttl)})
+
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		e := el.Value.(*entry[K, V])
+		if now.Before(e.expiry) && len(c.entries) <= c.maxEntries {
+			break
+		}
+
+		c.order.Remove(el)
+		delete(c.entries, e.key)
+	}
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
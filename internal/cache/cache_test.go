@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	c := New[int, string](time.Minute, 10)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get(1); ok {
+		t.Fatal("Get on an empty cache: got a value")
+	}
+
+	c.Set(1, "one")
+	c.Set(2, "two")
+
+	if got, ok := c.Get(1); !ok || got != "one" {
+		t.Errorf("Get(1): got %q, %t; want one, true", got, ok)
+	}
+
+	c.Delete(1)
+
+	if _, ok := c.Get(1); ok {
+		t.Error("Get(1) after Delete: got a value")
+	}
+	if got, ok := c.Get(2); !ok || got != "two" {
+		t.Errorf("Get(2) after Delete: got %q, %t; want two, true", got, ok)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, ok := c.Get(2); ok {
+		t.Error("Get(2) after expiry: got a value")
+	}
+
+	c.Set(3, "three")
+
+	if len(c.entries) != 1 || c.order.Len() != 1 {
+		t.Errorf("got %d entries after pruning; want 1", len(c.entries))
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	c := New[int, string](time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set(1, "one")
+	now = now.Add(time.Second)
+	c.Set(2, "two")
+	now = now.Add(time.Second)
+
+	// setting a key again makes it the newest
+	c.Set(1, "uno")
+	now = now.Add(time.Second)
+	c.Set(3, "three")
+
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Fatalf("got %d entries; want 2", len(c.entries))
+	}
+	if _, ok := c.Get(2); ok {
+		t.Error("Get(2): got a value; want the oldest entry dropped")
+	}
+	if got, ok := c.Get(1); !ok || got != "uno" {
+		t.Errorf("Get(1): got %q, %t; want uno, true", got, ok)
+	}
+	if got, ok := c.Get(3); !ok || got != "three" {
+		t.Errorf("Get(3): got %q, %t; want three, true", got, ok)
+	}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+// Actions that moderators and admins take on user accounts.
+const (
+	ActionSuspend         = "suspend"
+	ActionUnsuspend       = "unsuspend"
+	ActionLogout          = "logout"
+	ActionResetActivation = "reset_activation"
+	ActionSetRole         = "set_role"
+)
+
+// AdminAction records who took an action on a user's account and why.
+type AdminAction struct {
+	Id        int    `json:"id"`
+	ActorId   int    `json:"actor_id"`
+	UserId    int    `json:"user_id"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+type AdminActionModel struct {
+	db *sql.DB
+}
+
+func (m *AdminActionModel) Insert(action *AdminAction) error {
+	query := `
+		INSERT INTO admin_actions (actor_id, user_id, action, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{action.ActorId, action.UserId, action.Action, action.Reason}
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&action.Id, &action.CreatedAt)
+}
+
+// GetAll lists the actions taken, only those on one user when userId isn't zero.
+func (m *AdminActionModel) GetAll(userId int, filters Filters) ([]*AdminAction, Meta, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, actor_id, user_id, action, reason, created_at
+		FROM admin_actions
+		WHERE user_id = $1 OR $1 = 0
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var action AdminAction
+
+		err := rows.Scan(
+			&totalRecords,
+			&action.Id,
+			&action.ActorId,
+			&action.UserId,
+			&action.Action,
+			&action.Reason,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+
+		actions = append(actions, &action)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return actions, meta, nil
+}
+
+func ValidateReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateAPIKeyName = errors.New("duplicate api key name")
+
+// APIKeyPrefix starts every API key so that keys can be told apart from JWTs and
+// found by secret scanners.
+const APIKeyPrefix = "mtx_"
+
+// apiKeyLastUsedInterval limits how often the last use of a key is written.
+const apiKeyLastUsedInterval = time.Minute
+
+// APIKey is a long-lived credential that a user creates for scripts and
+// integrations. The key is shown once when it is created; only its SHA-256 hash
+// and a short prefix to recognise it by are stored.
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"-"`
+	Name       string     `json:"name"`
+	Plaintext  string     `json:"token,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Expiry     *time.Time `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+type APIKeyModel struct {
+	db *sql.DB
+}
+
+func generateAPIKey(userId int, name string, scopes []string, expiry *time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := APIKeyPrefix + strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+
+	return &APIKey{
+		UserId:    userId,
+		Name:      name,
+		Plaintext: plaintext,
+		Prefix:    plaintext[:len(APIKeyPrefix)+8],
+		Hash:      hashAPIKey(plaintext),
+		Scopes:    scopes,
+		Expiry:    expiry,
+	}, nil
+}
+
+func hashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// IsAPIKey reports whether a bearer credential is an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+func (m *APIKeyModel) New(userId int, name string, scopes []string, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userId, name, scopes, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(key)
+	return key, err
+}
+
+func (m *APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{key.UserId, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.Expiry}
+
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(&key.Id, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "unique_user_api_key_name"`:
+			return ErrDuplicateAPIKeyName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the unexpired key matching the plaintext token.
+func (m *APIKeyModel) GetForToken(plaintext string) (*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expiry, last_used_at, created_at
+		FROM api_keys
+		WHERE hash = $1 AND (expiry IS NULL OR expiry > now())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var key APIKey
+
+	err := m.db.QueryRowContext(ctx, query, hashAPIKey(plaintext)).Scan(
+		&key.Id,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m *APIKeyModel) GetAllForUser(userId int) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expiry, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.Id,
+			&key.UserId,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.Expiry,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Touch records that the key was just used. Writes are skipped while the recorded
+// time is recent, so busy keys don't cause a write per request.
+func (m *APIKeyModel) Touch(key *APIKey) error {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < apiKeyLastUsedInterval {
+		return nil
+	}
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, key.Id, time.Now().Add(-apiKeyLastUsedInterval))
+	return err
+}
+
+func (m *APIKeyModel) Delete(userId, id int) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// HasScope reports whether the key was granted the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return HasScope(k.Scopes, scope)
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	ValidateScopes(v, "scopes", key.Scopes, GrantableScopes)
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
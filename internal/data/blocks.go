@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBlocked is returned when an action between two users is refused because
+// either of them blocked the other.
+var ErrBlocked = errors.New("user is blocked")
+
+// BlockedUser is a user blocked by the requesting user.
+type BlockedUser struct {
+	User      *User     `json:"user"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+type BlockModel struct {
+	db *sql.DB
+}
+
+// notBlocked returns an SQL condition that holds when neither of the users with
+// the ids in the expressions a and b blocked the other.
+func notBlocked(a, b string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+				SELECT 1 FROM user_blocks ub
+				WHERE (ub.blocker_id = %[1]s AND ub.blocked_id = %[2]s) OR (ub.blocker_id = %[2]s AND ub.blocked_id = %[1]s)
+			)`, a, b)
+}
+
+// Insert blocks a user. Any friendship or friend request between the two users is
+// removed along with their places in each other's friend groups, and neither
+// keeps seeing the free times the other shared with them.
+// Blocking a user twice is not an error.
+func (m *BlockModel) Insert(blockerId, blockedId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		`INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		`DELETE FROM friend_group_members fgm
+		USING friend_groups fg
+		WHERE fg.id = fgm.group_id
+			AND ((fg.user_id = $1 AND fgm.user_id = $2) OR (fg.user_id = $2 AND fgm.user_id = $1))`,
+		`DELETE FROM friends
+		WHERE (source_user_id = $1 AND destination_user_id = $2) OR (source_user_id = $2 AND destination_user_id = $1)`,
+		`DELETE FROM free_time_viewer ftv
+		USING free_times ft
+		WHERE ft.id = ftv.free_time_id
+			AND ((ft.user_id = $1 AND ftv.user_id = $2) OR (ft.user_id = $2 AND ftv.user_id = $1))`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, blockerId, blockedId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete unblocks a user. Friendships severed by the block aren't restored.
+func (m *BlockModel) Delete(blockerId, blockedId int) error {
+	query := `
+		DELETE FROM user_blocks
+		WHERE blocker_id = $1 AND blocked_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, blockerId, blockedId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// IsBlocked reports whether either of the users blocked the other.
+func (m *BlockModel) IsBlocked(userId, otherId int) (bool, error) {
+	query := fmt.Sprintf(`SELECT NOT %s`, notBlocked("$1::bigint", "$2::bigint"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var blocked bool
+	err := m.db.QueryRowContext(ctx, query, userId, otherId).Scan(&blocked)
+	return blocked, err
+}
+
+// GetAllFor returns the users blocked by the user, most recently blocked first.
+func (m *BlockModel) GetAllFor(blockerId int, filters Filters) ([]*BlockedUser, Meta, error) {
+	query := `
+		SELECT count(*) OVER(), users.id, users.uuid, users.name, users.handle, users.avatar_url, user_blocks.created_at
+		FROM user_blocks
+		INNER JOIN users
+		ON users.id = user_blocks.blocked_id
+		WHERE user_blocks.blocker_id = $1 AND users.deleted_at IS NULL
+		ORDER BY user_blocks.created_at DESC, users.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, blockerId, filters.PageSize, filters.offset())
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	blocked := []*BlockedUser{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var b BlockedUser
+		var u User
+		err := rows.Scan(
+			&totalRecords,
+			&u.Id,
+			&u.Uuid,
+			&u.Name,
+			&u.Handle,
+			&u.AvatarUrl,
+			&b.BlockedAt,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		b.User = &u
+		blocked = append(blocked, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return blocked, meta, nil
+}
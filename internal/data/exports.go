@@ -0,0 +1,402 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// exportTimeout is how long an export may stay pending before it is considered
+// lost, e.g. to a restart, and a new one may be requested.
+const exportTimeout = time.Hour
+
+// DataExport is an archive of everything held about a user, assembled in the
+// background and downloaded until it expires.
+type DataExport struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"-"`
+	Status      string     `json:"status"`
+	Archive     []byte     `json:"-"`
+	Expiry      time.Time  `json:"expiry"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// PersonalData is everything held about a user that isn't a credential.
+type PersonalData struct {
+	Profile         *UserAccount      `json:"profile"`
+	Privacy         *PrivacySettings  `json:"privacy"`
+	Identities      []*Identity       `json:"identities"`
+	Friends         []*ExportedFriend `json:"friends"`
+	FriendRequests  []*ExportedFriend `json:"friend_requests"`
+	FreeTimes       []*FreeTime       `json:"free_times"`
+	FreeTimeViewers []*FreeTimeViewer `json:"free_time_viewers"`
+	ExportedAt      time.Time         `json:"exported_at"`
+}
+
+// ExportedFriend is a friendship or pending friend request of the exported user.
+// Direction tells whether the user sent or received the request.
+type ExportedFriend struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Direction string `json:"direction"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// FreeTimeViewer is a user that a free time was shared with.
+type FreeTimeViewer struct {
+	FreeTimeId int    `json:"free_time_id"`
+	UserId     int    `json:"user_id"`
+	UserName   string `json:"user_name"`
+}
+
+type DataExportModel struct {
+	db *sql.DB
+}
+
+// New starts a pending export for the user, replacing their previous exports.
+func (m *DataExportModel) New(userId int, ttl time.Duration) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM data_exports WHERE user_id = $1`, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO data_exports (user_id, status, expiry)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	export := &DataExport{
+		UserId: userId,
+		Status: ExportPending,
+		Expiry: time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	err = tx.QueryRowContext(ctx, query, userId, export.Status, export.Expiry).Scan(&export.Id, &export.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// GetLatestForUser returns the user's current export without its archive.
+func (m *DataExportModel) GetLatestForUser(userId int) (*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, expiry, created_at, completed_at
+		FROM data_exports
+		WHERE user_id = $1 AND expiry > now()
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var export DataExport
+
+	err := m.db.QueryRowContext(ctx, query, userId).Scan(
+		&export.Id,
+		&export.UserId,
+		&export.Status,
+		&export.Expiry,
+		&export.CreatedAt,
+		&export.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	// Exports interrupted by a restart never complete
+	if export.Status == ExportPending && time.Since(export.CreatedAt) > exportTimeout {
+		export.Status = ExportFailed
+	}
+
+	return &export, nil
+}
+
+// GetArchiveForUser returns the user's ready export together with its archive.
+func (m *DataExportModel) GetArchiveForUser(userId int) (*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, archive, expiry, created_at, completed_at
+		FROM data_exports
+		WHERE user_id = $1 AND status = $2 AND expiry > now()
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var export DataExport
+
+	err := m.db.QueryRowContext(ctx, query, userId, ExportReady).Scan(
+		&export.Id,
+		&export.UserId,
+		&export.Status,
+		&export.Archive,
+		&export.Expiry,
+		&export.CreatedAt,
+		&export.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// Complete stores the archive of a pending export, or marks it as failed when
+// archive is nil.
+func (m *DataExportModel) Complete(export *DataExport, archive []byte) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2, archive = $3, completed_at = now()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING status, completed_at`
+
+	status := ExportReady
+	if archive == nil {
+		status = ExportFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, export.Id, status, archive).Scan(&export.Status, &export.CompletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes the exports that can no longer be downloaded.
+func (m *DataExportModel) DeleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, `DELETE FROM data_exports WHERE expiry < now()`)
+	return err
+}
+
+// Collect gathers the personal data of a user.
+func (m *DataExportModel) Collect(userId int) (*PersonalData, error) {
+	users := UserModel{db: m.db}
+	identities := IdentityModel{db: m.db}
+
+	profile, err := users.GetAnyById(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pd := &PersonalData{
+		Profile:    profile.Account(),
+		ExportedAt: time.Now(),
+	}
+
+	pd.Privacy, err = users.GetPrivacy(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Identities, err = identities.GetAllForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	friends, err := m.collectFriends(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Friends = []*ExportedFriend{}
+	pd.FriendRequests = []*ExportedFriend{}
+	for _, friend := range friends {
+		if friend.Status == "accepted" {
+			pd.Friends = append(pd.Friends, friend)
+		} else {
+			pd.FriendRequests = append(pd.FriendRequests, friend)
+		}
+	}
+
+	pd.FreeTimes, err = m.collectFreeTimes(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.FreeTimeViewers, err = m.collectFreeTimeViewers(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pd, nil
+}
+
+func (m *DataExportModel) collectFriends(userId int) ([]*ExportedFriend, error) {
+	query := `
+		SELECT f.id, u.id, u.name,
+			CASE WHEN f.source_user_id = $1 THEN 'sent' ELSE 'received' END,
+			f.status, f.created_at, f.updated_at
+		FROM friends f
+		INNER JOIN users u
+		ON u.id = CASE WHEN f.source_user_id = $1 THEN f.destination_user_id ELSE f.source_user_id END
+		WHERE f.source_user_id = $1 OR f.destination_user_id = $1
+		ORDER BY f.created_at ASC, f.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	friends := []*ExportedFriend{}
+
+	for rows.Next() {
+		var friend ExportedFriend
+		err := rows.Scan(
+			&friend.Id,
+			&friend.UserId,
+			&friend.UserName,
+			&friend.Direction,
+			&friend.Status,
+			&friend.CreatedAt,
+			&friend.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		friends = append(friends, &friend)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return friends, nil
+}
+
+func (m *DataExportModel) collectFreeTimes(userId int) ([]*FreeTime, error) {
+	query := `
+		SELECT id, user_id, start_time, end_time, created_at, updated_at, tags, visibility, version
+		FROM free_times
+		WHERE user_id = $1
+		ORDER BY start_time ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	freetimes := []*FreeTime{}
+
+	for rows.Next() {
+		var ft FreeTime
+		err := rows.Scan(
+			&ft.Id,
+			&ft.UserId,
+			&ft.StartTime,
+			&ft.EndTime,
+			&ft.CreatedAt,
+			&ft.UpdatedAt,
+			pq.Array(&ft.Tags),
+			&ft.Visibility,
+			&ft.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		freetimes = append(freetimes, &ft)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return freetimes, nil
+}
+
+func (m *DataExportModel) collectFreeTimeViewers(userId int) ([]*FreeTimeViewer, error) {
+	query := `
+		SELECT ftv.free_time_id, u.id, u.name
+		FROM free_time_viewer ftv
+		INNER JOIN free_times ft
+		ON ft.id = ftv.free_time_id
+		INNER JOIN users u
+		ON u.id = ftv.user_id
+		WHERE ft.user_id = $1
+		ORDER BY ftv.free_time_id ASC, u.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewers := []*FreeTimeViewer{}
+
+	for rows.Next() {
+		var viewer FreeTimeViewer
+		err := rows.Scan(&viewer.FreeTimeId, &viewer.UserId, &viewer.UserName)
+		if err != nil {
+			return nil, err
+		}
+		viewers = append(viewers, &viewer)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return viewers, nil
+}
@@ -56,6 +56,22 @@ func calculateMeta(totalRecords, page, pageSize int) Meta {
 	}
 }
 
+// Paginate returns the page of items that the filters ask for, for lists that are
+// held in memory rather than paged by a query.
+func Paginate[T any](items []T, filters Filters) ([]T, Meta) {
+	start := filters.offset()
+	if start > len(items) {
+		start = len(items)
+	}
+
+	end := start + filters.limit()
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end], calculateMeta(len(items), filters.Page, filters.PageSize)
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Page parameters
 	v.Check(f.Page > 0, "page", "must be greater than zero")
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	t.Parallel()
+
+	items := []int{1, 2, 3, 4, 5}
+
+	tests := []struct {
+		page     int
+		pageSize int
+		want     []int
+		meta     Meta
+	}{
+		{page: 1, pageSize: 2, want: []int{1, 2}, meta: Meta{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{page: 3, pageSize: 2, want: []int{5}, meta: Meta{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{page: 4, pageSize: 2, want: []int{}, meta: Meta{CurrentPage: 4, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{page: 1, pageSize: 10, want: []int{1, 2, 3, 4, 5}, meta: Meta{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5}},
+	}
+
+	for _, tt := range tests {
+		got, meta := Paginate(items, Filters{Page: tt.page, PageSize: tt.pageSize})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Paginate(page %d, size %d): got %v; want %v", tt.page, tt.pageSize, got, tt.want)
+		}
+		if meta != tt.meta {
+			t.Errorf("Paginate(page %d, size %d): got meta %+v; want %+v", tt.page, tt.pageSize, meta, tt.meta)
+		}
+	}
+
+	if got, meta := Paginate([]int{}, Filters{Page: 1, PageSize: 10}); len(got) != 0 || meta != (Meta{}) {
+		t.Errorf("Paginate(empty): got %v, %+v; want no items and empty meta", got, meta)
+	}
+}
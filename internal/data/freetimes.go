@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,6 +11,28 @@ import (
 	"github.com/lib/pq"
 )
 
+// Visibilities of a free time, deciding which users see it besides its owner.
+const (
+	// FreeTimePublic free times are seen by every user that isn't blocked.
+	FreeTimePublic = "public"
+	// FreeTimeFriends free times are seen by all of the owner's friends.
+	FreeTimeFriends = "friends"
+	// FreeTimeGroups free times are seen by the friends that are members of any
+	// of its Groups at the time they look.
+	FreeTimeGroups = "groups"
+	// FreeTimeSpecific free times are seen by the friends listed as its Viewers.
+	FreeTimeSpecific = "specific"
+	// FreeTimePrivate free times are only seen by their owner.
+	FreeTimePrivate = "private"
+)
+
+var FreeTimeVisibilities = []string{FreeTimePublic, FreeTimeFriends, FreeTimeGroups, FreeTimeSpecific, FreeTimePrivate}
+
+var ErrUnknownFriendGroup = errors.New("unknown friend group")
+
+// maxFreeTimeViewers limits how many viewers or groups a free time is shared with.
+const maxFreeTimeViewers = 200
+
 type FreeTime struct {
 	Id         int       `json:"id"`
 	UserId     int       `json:"user_id"`
@@ -19,9 +42,35 @@ type FreeTime struct {
 	UpdatedAt  time.Time `json:"updated_at,omitempty"`
 	Tags       []string  `json:"tags,omitempty"`
 	Visibility string    `json:"visibility,omitempty"`
+	Viewers    []int64   `json:"viewers,omitempty"`
+	Groups     []int64   `json:"groups,omitempty"`
 	Version    int       `json:"version,omitempty"`
 }
 
+// freeTimeVisibleTo returns an SQL condition that holds when the user with the id
+// in the expression viewer may see the free_times row aliased table.
+func freeTimeVisibleTo(table, viewer string) string {
+	return fmt.Sprintf(`(%[1]s.user_id = %[2]s OR (%[3]s AND (
+				%[1]s.visibility = 'public' OR (%[1]s.visibility IN ('friends', 'groups', 'specific') AND EXISTS (
+					SELECT 1 FROM friends vf
+					WHERE vf.status = 'accepted' AND (
+						(vf.source_user_id = %[1]s.user_id AND vf.destination_user_id = %[2]s) OR
+						(vf.source_user_id = %[2]s AND vf.destination_user_id = %[1]s.user_id))
+				) AND (
+					%[1]s.visibility = 'friends' OR
+					(%[1]s.visibility = 'groups' AND EXISTS (
+						SELECT 1 FROM free_time_groups ftg
+						INNER JOIN friend_group_members fgm ON fgm.group_id = ftg.group_id
+						WHERE ftg.free_time_id = %[1]s.id AND fgm.user_id = %[2]s
+					)) OR
+					(%[1]s.visibility = 'specific' AND EXISTS (
+						SELECT 1 FROM free_time_viewer ftv
+						WHERE ftv.free_time_id = %[1]s.id AND ftv.user_id = %[2]s
+					))
+				))
+			)))`, table, viewer, notBlocked(table+".user_id", viewer))
+}
+
 type FreeTimeModel struct {
 	db *sql.DB
 }
@@ -30,7 +79,8 @@ func NewFreeTimeModel(db *sql.DB) *FreeTimeModel {
 	return &FreeTimeModel{db: db}
 }
 
-func (ft *FreeTimeModel) Insert(freetime *FreeTime, viewers []int) (*FreeTime, error) {
+// Insert adds a free time along with the viewers or groups it is shared with.
+func (ft *FreeTimeModel) Insert(freetime *FreeTime) (*FreeTime, error) {
 	insertFreetimeQuery := `
 		INSERT INTO free_times (user_id, start_time, end_time, tags, visibility)
 		VALUES ($1, $2, $3, $4, $5)
@@ -63,16 +113,10 @@ func (ft *FreeTimeModel) Insert(freetime *FreeTime, viewers []int) (*FreeTime, e
 		return nil, err
 	}
 
-	insertViewerQuery := `
-			INSERT INTO free_time_viewer (free_time_id, user_id)
-			VALUES ($1, $2)`
-
-	for _, viewerID := range viewers {
-		_, err = tx.ExecContext(ctx, insertViewerQuery, freetime.Id, viewerID)
-		if err != nil {
-			tx.Rollback()
-			return nil, err
-		}
+	err = setFreeTimeSharing(ctx, tx, freetime)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
 	err = tx.Commit()
@@ -83,9 +127,80 @@ func (ft *FreeTimeModel) Insert(freetime *FreeTime, viewers []int) (*FreeTime, e
 	return freetime, nil
 }
 
+// setFreeTimeSharing replaces the viewers and groups a free time is shared with.
+// Viewers must be friends of the owner, and groups must belong to the owner.
+func setFreeTimeSharing(ctx context.Context, tx *sql.Tx, freetime *FreeTime) error {
+	for _, query := range []string{
+		`DELETE FROM free_time_viewer WHERE free_time_id = $1`,
+		`DELETE FROM free_time_groups WHERE free_time_id = $1`,
+	} {
+		_, err := tx.ExecContext(ctx, query, freetime.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(freetime.Viewers) > 0 {
+		query := `
+			INSERT INTO free_time_viewer (free_time_id, user_id)
+			SELECT $1, v.id
+			FROM unnest($3::bigint[]) AS v(id)
+			WHERE EXISTS (
+				SELECT 1 FROM friends f
+				WHERE f.status = 'accepted' AND (
+					(f.source_user_id = $2 AND f.destination_user_id = v.id) OR
+					(f.source_user_id = v.id AND f.destination_user_id = $2))
+			)
+			ON CONFLICT DO NOTHING`
+
+		result, err := tx.ExecContext(ctx, query, freetime.Id, freetime.UserId, pq.Array(freetime.Viewers))
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if int(rows) != len(freetime.Viewers) {
+			return ErrNotFriends
+		}
+	}
+
+	if len(freetime.Groups) > 0 {
+		query := `
+			INSERT INTO free_time_groups (free_time_id, group_id)
+			SELECT $1, fg.id
+			FROM friend_groups fg
+			WHERE fg.user_id = $2 AND fg.id = ANY($3::bigint[])
+			ON CONFLICT DO NOTHING`
+
+		result, err := tx.ExecContext(ctx, query, freetime.Id, freetime.UserId, pq.Array(freetime.Groups))
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if int(rows) != len(freetime.Groups) {
+			return ErrUnknownFriendGroup
+		}
+	}
+
+	return nil
+}
+
+// Get returns the free time with the id, along with the viewers and groups it is
+// shared with.
 func (ft *FreeTimeModel) Get(freetimeId int) (*FreeTime, error) {
 	query := `
-		SELECT id, user_id, start_time, end_time, created_at, updated_at, tags, visibility, version
+		SELECT id, user_id, start_time, end_time, created_at, updated_at, tags, visibility, version,
+			ARRAY(SELECT user_id FROM free_time_viewer WHERE free_time_id = free_times.id ORDER BY user_id),
+			ARRAY(SELECT group_id FROM free_time_groups WHERE free_time_id = free_times.id ORDER BY group_id)
 		FROM free_times
 		WHERE id = $1`
 
@@ -104,6 +219,8 @@ func (ft *FreeTimeModel) Get(freetimeId int) (*FreeTime, error) {
 		pq.Array(&freetime.Tags),
 		&freetime.Visibility,
 		&freetime.Version,
+		pq.Array(&freetime.Viewers),
+		pq.Array(&freetime.Groups),
 	)
 	if err != nil {
 		switch err {
@@ -117,14 +234,19 @@ func (ft *FreeTimeModel) Get(freetimeId int) (*FreeTime, error) {
 	return &freetime, nil
 }
 
-func (ft *FreeTimeModel) GetAllFor(userId int, filters Filters, start, end time.Time) ([]*FreeTime, Meta, error) {
+// GetAllFor returns the free times of the user that the user with the id
+// viewerId may see. Only the owner sees who their free times are shared with.
+func (ft *FreeTimeModel) GetAllFor(userId, viewerId int, filters Filters, start, end time.Time) ([]*FreeTime, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), 
-			id, user_id, start_time, end_time, created_at, updated_at, tags, visibility
+			id, user_id, start_time, end_time, created_at, updated_at, tags, visibility,
+			CASE WHEN user_id = $6 THEN ARRAY(SELECT user_id FROM free_time_viewer WHERE free_time_id = free_times.id ORDER BY user_id) END,
+			CASE WHEN user_id = $6 THEN ARRAY(SELECT group_id FROM free_time_groups WHERE free_time_id = free_times.id ORDER BY group_id) END
 		FROM free_times
 		WHERE user_id = $1 AND start_time > $2 AND end_time < $3
+			AND %s
 		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $4 OFFSET $5`, freeTimeVisibleTo("free_times", "$6"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
@@ -135,6 +257,7 @@ func (ft *FreeTimeModel) GetAllFor(userId int, filters Filters, start, end time.
 		end,
 		filters.PageSize,
 		filters.offset(),
+		viewerId,
 	}
 
 	rows, err := ft.db.QueryContext(ctx, query, args...)
@@ -163,6 +286,8 @@ func (ft *FreeTimeModel) GetAllFor(userId int, filters Filters, start, end time.
 			&ft.UpdatedAt,
 			pq.Array(&ft.Tags),
 			&ft.Visibility,
+			pq.Array(&ft.Viewers),
+			pq.Array(&ft.Groups),
 		)
 		if err != nil {
 			return nil, Meta{}, err
@@ -175,6 +300,7 @@ func (ft *FreeTimeModel) GetAllFor(userId int, filters Filters, start, end time.
 	return freetimes, meta, nil
 }
 
+// Update saves the free time, replacing the viewers and groups it is shared with.
 func (ft *FreeTimeModel) Update(freetime *FreeTime) (*FreeTime, error) {
 	query := `
 		UPDATE free_times
@@ -182,9 +308,14 @@ func (ft *FreeTimeModel) Update(freetime *FreeTime) (*FreeTime, error) {
 		WHERE id = $5 AND version = $6
 		RETURNING version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout+5*time.Second)
 	defer cancel()
 
+	tx, err := ft.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	args := []interface{}{
 		freetime.StartTime,
 		freetime.EndTime,
@@ -194,8 +325,9 @@ func (ft *FreeTimeModel) Update(freetime *FreeTime) (*FreeTime, error) {
 		freetime.Version,
 	}
 
-	err := ft.db.QueryRowContext(ctx, query, args...).Scan(&freetime.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&freetime.Version)
 	if err != nil {
+		tx.Rollback()
 		switch err {
 		case sql.ErrNoRows:
 			return nil, ErrRecordNotFound
@@ -204,6 +336,17 @@ func (ft *FreeTimeModel) Update(freetime *FreeTime) (*FreeTime, error) {
 		}
 	}
 
+	err = setFreeTimeSharing(ctx, tx, freetime)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
 	return freetime, nil
 }
 
@@ -234,14 +377,14 @@ type FriendFreeTime struct {
 	Tags            []string  `json:"tags"`
 }
 
-func (ft *FreeTimeModel) GetAllForFriendsOf(userId int, filters Filters, start, end time.Time) ([]*FriendFreeTime, Meta, error) {
+func (ft *FreeTimeModel) GetAllForFriendsOf(userId, groupId int, filters Filters, start, end time.Time) ([]*FriendFreeTime, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT 
 			count(*) OVER(),
 			ft.id as free_time_id, 
 			u.id as friend_id, 
 			u.name as friend_name, 
-			u.email as friend_email,
+			%s as friend_email,
 			u.avatar_url,
 			ft.start_time, 
 			ft.end_time, 
@@ -257,15 +400,29 @@ func (ft *FreeTimeModel) GetAllForFriendsOf(userId int, filters Filters, start,
 		WHERE 
 			(f.source_user_id = $1 OR f.destination_user_id = $1)
 			AND f.status = 'accepted'
+			AND u.suspended_at IS NULL
+			AND u.deleted_at IS NULL
+			AND %s
+			AND %s
+			AND %s
 			AND ft.start_time > $2
 			AND ft.end_time < $3
 		ORDER BY ft.%s %s, ft.id ASC
-		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $4 OFFSET $5`, emailVisibleTo("u", "$1"), notBlocked("u.id", "$1"), inFriendGroup("u.id", "$1", "$6"), freeTimeVisibleTo("ft", "$1"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
-	rows, err := ft.db.QueryContext(ctx, query, userId)
+	args := []interface{}{
+		userId,
+		start,
+		end,
+		filters.PageSize,
+		filters.offset(),
+		groupId,
+	}
+
+	rows, err := ft.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -307,7 +464,34 @@ func ValidateFreeTime(v *validator.Validator, freetime *FreeTime) bool {
 	v.Check(freetime.UserId > 0, "user_id", "must be valid")
 	v.Check(freetime.StartTime.After(time.Now()), "start_time", "must be in the future")
 	v.Check(freetime.StartTime.Before(freetime.EndTime), "end_time", "must be after start time")
-	v.Check(freetime.Visibility == "public" || freetime.Visibility == "private", "visibility", "must be either public or private")
+	v.Check(validator.In(freetime.Visibility, FreeTimeVisibilities...), "visibility", "must be one of public, friends, groups, specific or private")
+
+	if freetime.Visibility == FreeTimeSpecific {
+		v.Check(len(freetime.Viewers) > 0, "viewers", "must be provided when visibility is specific")
+	} else {
+		v.Check(len(freetime.Viewers) == 0, "viewers", "must only be provided when visibility is specific")
+	}
+	v.Check(len(freetime.Viewers) <= maxFreeTimeViewers, "viewers", fmt.Sprintf("must not contain more than %d users", maxFreeTimeViewers))
+	v.Check(uniqueIds(freetime.Viewers), "viewers", "must not contain duplicate users")
+
+	if freetime.Visibility == FreeTimeGroups {
+		v.Check(len(freetime.Groups) > 0, "groups", "must be provided when visibility is groups")
+	} else {
+		v.Check(len(freetime.Groups) == 0, "groups", "must only be provided when visibility is groups")
+	}
+	v.Check(len(freetime.Groups) <= maxFreeTimeViewers, "groups", fmt.Sprintf("must not contain more than %d groups", maxFreeTimeViewers))
+	v.Check(uniqueIds(freetime.Groups), "groups", "must not contain duplicate groups")
 
 	return v.Valid()
 }
+
+func uniqueIds(ids []int64) bool {
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+func TestValidateFreeTime(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]int64, maxFreeTimeViewers+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ft *FreeTime)
+		// errKey is the field expected to fail, or empty when the free time is valid
+		errKey string
+	}{
+		{name: "public", modify: func(ft *FreeTime) {}},
+		{name: "private", modify: func(ft *FreeTime) { ft.Visibility = FreeTimePrivate }},
+		{name: "friends", modify: func(ft *FreeTime) { ft.Visibility = FreeTimeFriends }},
+		{name: "specific with viewers", modify: func(ft *FreeTime) { ft.Visibility, ft.Viewers = FreeTimeSpecific, []int64{2, 3} }},
+		{name: "groups with groups", modify: func(ft *FreeTime) { ft.Visibility, ft.Groups = FreeTimeGroups, []int64{1} }},
+		{name: "no user", modify: func(ft *FreeTime) { ft.UserId = 0 }, errKey: "user_id"},
+		{name: "start in past", modify: func(ft *FreeTime) { ft.StartTime = time.Now().Add(-time.Minute) }, errKey: "start_time"},
+		{name: "end before start", modify: func(ft *FreeTime) { ft.EndTime = ft.StartTime.Add(-time.Minute) }, errKey: "end_time"},
+		{name: "end at start", modify: func(ft *FreeTime) { ft.EndTime = ft.StartTime }, errKey: "end_time"},
+		{name: "unknown visibility", modify: func(ft *FreeTime) { ft.Visibility = "secret" }, errKey: "visibility"},
+		{name: "empty visibility", modify: func(ft *FreeTime) { ft.Visibility = "" }, errKey: "visibility"},
+		{name: "specific without viewers", modify: func(ft *FreeTime) { ft.Visibility = FreeTimeSpecific }, errKey: "viewers"},
+		{name: "viewers when public", modify: func(ft *FreeTime) { ft.Viewers = []int64{2} }, errKey: "viewers"},
+		{name: "duplicate viewers", modify: func(ft *FreeTime) { ft.Visibility, ft.Viewers = FreeTimeSpecific, []int64{2, 2} }, errKey: "viewers"},
+		{name: "too many viewers", modify: func(ft *FreeTime) { ft.Visibility, ft.Viewers = FreeTimeSpecific, tooMany }, errKey: "viewers"},
+		{name: "groups without groups", modify: func(ft *FreeTime) { ft.Visibility = FreeTimeGroups }, errKey: "groups"},
+		{name: "groups when friends", modify: func(ft *FreeTime) { ft.Visibility, ft.Groups = FreeTimeFriends, []int64{1} }, errKey: "groups"},
+		{name: "duplicate groups", modify: func(ft *FreeTime) { ft.Visibility, ft.Groups = FreeTimeGroups, []int64{1, 1} }, errKey: "groups"},
+		{name: "too many groups", modify: func(ft *FreeTime) { ft.Visibility, ft.Groups = FreeTimeGroups, tooMany }, errKey: "groups"},
+	}
+
+	for _, tt := range tests {
+		start := time.Now().Add(time.Hour)
+		ft := &FreeTime{
+			UserId:     1,
+			StartTime:  start,
+			EndTime:    start.Add(time.Hour),
+			Visibility: FreeTimePublic,
+		}
+		tt.modify(ft)
+
+		v := validator.New()
+		valid := ValidateFreeTime(v, ft)
+
+		switch {
+		case tt.errKey == "" && !valid:
+			t.Errorf("%s: got errors %v; want valid", tt.name, v.Errors)
+		case tt.errKey != "" && v.Errors[tt.errKey] == "":
+			t.Errorf("%s: got errors %v; want an error for %s", tt.name, v.Errors, tt.errKey)
+		}
+	}
+}
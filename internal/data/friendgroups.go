@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+var (
+	ErrDuplicateFriendGroupName = errors.New("duplicate friend group name")
+	ErrNotFriends               = errors.New("users are not friends")
+)
+
+// FriendGroup is a named group, or circle, that a user sorts their friends into.
+// Groups are private to the user that owns them.
+type FriendGroup struct {
+	Id          int       `json:"id"`
+	UserId      int       `json:"-"`
+	Name        string    `json:"name"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int       `json:"version"`
+}
+
+type FriendGroupModel struct {
+	db *sql.DB
+}
+
+// inFriendGroup returns an SQL condition that holds when the user with the id in
+// the expression member is in the group with the id in the expression group,
+// owned by the user with the id in the expression owner. A group id of 0 matches
+// every user.
+func inFriendGroup(member, owner, group string) string {
+	return fmt.Sprintf(`(%[3]s = 0 OR %[1]s IN (
+				SELECT fgm.user_id FROM friend_group_members fgm
+				INNER JOIN friend_groups fg ON fg.id = fgm.group_id
+				WHERE fg.id = %[3]s AND fg.user_id = %[2]s
+			))`, member, owner, group)
+}
+
+func (m *FriendGroupModel) Insert(group *FriendGroup) error {
+	query := `
+		INSERT INTO friend_groups (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, group.UserId, group.Name).Scan(
+		&group.Id,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+		&group.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "unique_user_friend_group_name"`:
+			return ErrDuplicateFriendGroupName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the group with the id if it is owned by the user.
+func (m *FriendGroupModel) Get(id, userId int) (*FriendGroup, error) {
+	query := `
+		SELECT fg.id, fg.user_id, fg.name, fg.created_at, fg.updated_at, fg.version,
+			(SELECT count(*) FROM friend_group_members fgm WHERE fgm.group_id = fg.id)
+		FROM friend_groups fg
+		WHERE fg.id = $1 AND fg.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var group FriendGroup
+
+	err := m.db.QueryRowContext(ctx, query, id, userId).Scan(
+		&group.Id,
+		&group.UserId,
+		&group.Name,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+		&group.Version,
+		&group.MemberCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &group, nil
+}
+
+// GetAllFor returns the groups owned by the user, sorted by name.
+func (m *FriendGroupModel) GetAllFor(userId int, filters Filters) ([]*FriendGroup, Meta, error) {
+	query := `
+		SELECT count(*) OVER(), fg.id, fg.user_id, fg.name, fg.created_at, fg.updated_at, fg.version,
+			(SELECT count(*) FROM friend_group_members fgm WHERE fgm.group_id = fg.id)
+		FROM friend_groups fg
+		WHERE fg.user_id = $1
+		ORDER BY fg.name ASC, fg.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId, filters.PageSize, filters.offset())
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	groups := []*FriendGroup{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var group FriendGroup
+		err := rows.Scan(
+			&totalRecords,
+			&group.Id,
+			&group.UserId,
+			&group.Name,
+			&group.CreatedAt,
+			&group.UpdatedAt,
+			&group.Version,
+			&group.MemberCount,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		groups = append(groups, &group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return groups, meta, nil
+}
+
+func (m *FriendGroupModel) Update(group *FriendGroup) error {
+	query := `
+		UPDATE friend_groups
+		SET name = $3, updated_at = now(), version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, group.Id, group.Version, group.Name).Scan(&group.UpdatedAt, &group.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "unique_user_friend_group_name"`:
+			return ErrDuplicateFriendGroupName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *FriendGroupModel) Delete(group *FriendGroup) error {
+	query := `
+		DELETE FROM friend_groups
+		WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, group.Id, group.Version)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// AddMember adds a friend of the group's owner to the group. Adding a member
+// twice is not an error.
+func (m *FriendGroupModel) AddMember(group *FriendGroup, friendId int) error {
+	query := `
+		INSERT INTO friend_group_members (group_id, user_id)
+		SELECT $1, $3
+		WHERE EXISTS (
+			SELECT 1 FROM friends f
+			WHERE f.status = 'accepted' AND (
+				(f.source_user_id = $2 AND f.destination_user_id = $3) OR
+				(f.source_user_id = $3 AND f.destination_user_id = $2))
+		)
+		ON CONFLICT (group_id, user_id) DO UPDATE SET added_at = friend_group_members.added_at
+		RETURNING added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var addedAt time.Time
+
+	err := m.db.QueryRowContext(ctx, query, group.Id, group.UserId, friendId).Scan(&addedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFriends
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *FriendGroupModel) RemoveMember(group *FriendGroup, friendId int) error {
+	query := `
+		DELETE FROM friend_group_members
+		WHERE group_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, group.Id, friendId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateFriendGroup(v *validator.Validator, group *FriendGroup) {
+	v.Check(group.Name != "", "name", "must be provided")
+	v.Check(len(group.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
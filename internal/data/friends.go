@@ -58,11 +58,13 @@ func (fp *FriendPairModel) GetRequest(friendRequestId int) (*FriendRequest, erro
 	return &f, nil
 }
 
+// Insert sends a friend request, unless either user blocked the other.
 func (fp *FriendPairModel) Insert(friendRequest *FriendRequest) error {
-	query := `
+	query := fmt.Sprintf(`
 		INSERT INTO friends (source_user_id, destination_user_id, status)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at, updated_at, version`
+		SELECT $1, $2, $3
+		WHERE %s
+		RETURNING id, created_at, updated_at, version`, notBlocked("$1::bigint", "$2::bigint"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
@@ -75,6 +77,8 @@ func (fp *FriendPairModel) Insert(friendRequest *FriendRequest) error {
 	)
 	if err != nil {
 		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrBlocked
 		case err.Error() == `pq: duplicate key value violates unique constraint "unique_friendship_pair"`:
 			return ErrDuplicateFriendRequest
 		default:
@@ -146,23 +150,27 @@ func (fp *FriendPairModel) GetFriend(id, friendId int) (*FriendRequest, error) {
 	return &friend, nil
 }
 
-func (fp *FriendPairModel) GetFriendsFor(id int, filters Filters) ([]*User, Meta, error) {
+// GetFriendsFor returns the friends of the user, only those in the user's friend
+// group with the id groupId unless it is 0.
+func (fp *FriendPairModel) GetFriendsFor(id, groupId int, filters Filters) ([]*User, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT 
-			count(*) OVER(), users.id, users.uuid, users.name, users.email, users.avatar_url
+			count(*) OVER(), users.id, users.uuid, users.name, users.handle, %s, users.avatar_url
 		FROM friends
 		INNER JOIN users
 		ON 
 			(users.id = friends.source_user_id OR users.id = friends.destination_user_id) AND users.id != $1
 		WHERE 
 			(friends.source_user_id = $1 OR friends.destination_user_id = $1) AND friends.status = 'accepted'
+			AND users.suspended_at IS NULL AND users.deleted_at IS NULL
+			AND %s
 		ORDER BY friends.%s %s, users.id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $2 OFFSET $3`, emailVisibleTo("users", "$1"), inFriendGroup("users.id", "$1", "$4"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
-	rows, err := fp.db.QueryContext(ctx, query, id, filters.PageSize, filters.offset())
+	rows, err := fp.db.QueryContext(ctx, query, id, filters.PageSize, filters.offset(), groupId)
 	if err != nil {
 		return nil, Meta{}, err
 	}
@@ -178,6 +186,7 @@ func (fp *FriendPairModel) GetFriendsFor(id int, filters Filters) ([]*User, Meta
 			&user.Id,
 			&user.Uuid,
 			&user.Name,
+			&user.Handle,
 			&user.Email,
 			&user.AvatarUrl,
 		)
@@ -198,14 +207,14 @@ func (fp *FriendPairModel) GetFriendsFor(id int, filters Filters) ([]*User, Meta
 func (fp *FriendPairModel) GetSentFor(id int, filters Filters) ([]*DetailedFriendRequest, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), 
-			friends.id, users.id as user_id, users.name as user_name, users.email, users.avatar_url, 
+			friends.id, users.id as user_id, users.name as user_name, users.handle, %s, users.avatar_url, 
 			friends.status, friends.created_at 
 		FROM friends
 		INNER JOIN users
 		ON users.id = friends.destination_user_id
-		WHERE source_user_id = $1 AND status = 'pending'
+		WHERE source_user_id = $1 AND status = 'pending' AND users.suspended_at IS NULL AND users.deleted_at IS NULL
 		ORDER BY friends.%s %s, users.id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $2 OFFSET $3`, emailVisibleTo("users", "$1"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
@@ -232,6 +241,7 @@ func (fp *FriendPairModel) GetSentFor(id int, filters Filters) ([]*DetailedFrien
 			&fr.Id,
 			&du.Id,
 			&du.Name,
+			&du.Handle,
 			&du.Email,
 			&du.AvatarUrl,
 			&fr.Status,
@@ -257,13 +267,13 @@ func (fp *FriendPairModel) GetSentFor(id int, filters Filters) ([]*DetailedFrien
 func (fp *FriendPairModel) GetReceivedFor(id int, filters Filters) ([]*DetailedFriendRequest, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(),
-			friends.id, users.id as user_id, users.name as user_name, users.email, users.avatar_url, friends.status, friends.created_at 
+			friends.id, users.id as user_id, users.name as user_name, users.handle, %s, users.avatar_url, friends.status, friends.created_at 
 		FROM friends
 		INNER JOIN users
 		ON users.id = friends.source_user_id
-		WHERE destination_user_id = $1 AND status = 'pending'
+		WHERE destination_user_id = $1 AND status = 'pending' AND users.suspended_at IS NULL AND users.deleted_at IS NULL
 		ORDER BY friends.%s %s, users.id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $2 OFFSET $3`, emailVisibleTo("users", "$1"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
@@ -290,6 +300,7 @@ func (fp *FriendPairModel) GetReceivedFor(id int, filters Filters) ([]*DetailedF
 			&fr.Id,
 			&su.Id,
 			&su.Name,
+			&su.Handle,
 			&su.Email,
 			&su.AvatarUrl,
 			&fr.Status,
@@ -312,20 +323,29 @@ func (fp *FriendPairModel) GetReceivedFor(id int, filters Filters) ([]*DetailedF
 	return friendRequests, meta, nil
 }
 
+// Delete removes a friend request or friendship, taking each user out of the
+// other's friend groups.
 func (fp *FriendPairModel) Delete(friendRequest *FriendRequest) error {
 	query := `
-		DELETE FROM friends
-		WHERE id = $1 AND version = $2`
+		WITH removed AS (
+			DELETE FROM friends
+			WHERE id = $1 AND version = $2
+			RETURNING source_user_id, destination_user_id
+		), memberships AS (
+			DELETE FROM friend_group_members fgm
+			USING friend_groups fg, removed r
+			WHERE fg.id = fgm.group_id AND (
+				(fg.user_id = r.source_user_id AND fgm.user_id = r.destination_user_id) OR
+				(fg.user_id = r.destination_user_id AND fgm.user_id = r.source_user_id))
+		)
+		SELECT count(*) FROM removed`
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
-	result, err := fp.db.ExecContext(ctx, query, friendRequest.Id, friendRequest.Version)
-	if err != nil {
-		return err
-	}
+	var rowsAffected int
 
-	rowsAffected, err := result.RowsAffected()
+	err := fp.db.QueryRowContext(ctx, query, friendRequest.Id, friendRequest.Version).Scan(&rowsAffected)
 	if err != nil {
 		return err
 	}
@@ -340,15 +360,16 @@ func (fp *FriendPairModel) Delete(friendRequest *FriendRequest) error {
 func (fp *FriendPairModel) SearchFor(id int, q string, filters Filters) ([]*User, Meta, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), 
-			users.id, users.name, users.email, users.avatar_url 
+			users.id, users.name, users.handle, %s, users.avatar_url 
 		FROM friends
 		INNER JOIN users
 		ON (users.id = friends.source_user_id OR users.id = friends.destination_user_id) AND users.id != $1
 		WHERE 
 			(friends.source_user_id = $1 OR friends.destination_user_id = $1) AND friends.status = 'accepted'
-			AND search @@ plainto_tsquery($2)
+			AND users.suspended_at IS NULL AND users.deleted_at IS NULL
+			AND (search @@ plainto_tsquery($2) OR %s)
 		ORDER BY ts_rank(search, plainto_tsquery($2)), friends.%s %s, users.id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $3 OFFSET $4`, emailVisibleTo("users", "$1"), emailMatches("users", "$1", "$2"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
@@ -375,6 +396,7 @@ func (fp *FriendPairModel) SearchFor(id int, q string, filters Filters) ([]*User
 			&totalRecords,
 			&u.Id,
 			&u.Name,
+			&u.Handle,
 			&u.Email,
 			&u.AvatarUrl,
 		)
@@ -389,6 +411,146 @@ func (fp *FriendPairModel) SearchFor(id int, q string, filters Filters) ([]*User
 
 }
 
+// Suggestion is a user that the requesting user may know, through the friends
+// they have in common.
+type Suggestion struct {
+	User        *User `json:"user"`
+	MutualCount int   `json:"mutual_count"`
+}
+
+// GetMutualFor returns the friends that the user with the id viewerId has in
+// common with the user with the id otherId.
+func (fp *FriendPairModel) GetMutualFor(viewerId, otherId int, filters Filters) ([]*User, Meta, error) {
+	query := fmt.Sprintf(`
+		WITH edges AS (
+			SELECT source_user_id AS user_id, destination_user_id AS friend_id FROM friends WHERE status = 'accepted'
+			UNION ALL
+			SELECT destination_user_id, source_user_id FROM friends WHERE status = 'accepted'
+		)
+		SELECT count(*) OVER(), users.id, users.uuid, users.name, users.handle, %s, users.avatar_url
+		FROM edges mine
+		INNER JOIN edges theirs
+		ON theirs.friend_id = mine.friend_id AND theirs.user_id = $2
+		INNER JOIN users
+		ON users.id = mine.friend_id
+		WHERE mine.user_id = $1
+			AND users.suspended_at IS NULL AND users.deleted_at IS NULL
+			AND %s
+		ORDER BY users.name ASC, users.id ASC
+		LIMIT $3 OFFSET $4`, emailVisibleTo("users", "$1"), notBlocked("users.id", "$1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := fp.db.QueryContext(ctx, query, viewerId, otherId, filters.PageSize, filters.offset())
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	mutual := []*User{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var u User
+		err := rows.Scan(
+			&totalRecords,
+			&u.Id,
+			&u.Uuid,
+			&u.Name,
+			&u.Handle,
+			&u.Email,
+			&u.AvatarUrl,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		mutual = append(mutual, &u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return mutual, meta, nil
+}
+
+// GetSuggestionsFor returns up to limit friends of the user's friends, ranked by
+// the number of friends they have in common with the user. Users that are already
+// friends with the user, have a pending request with them, are blocked either
+// way, or don't want to be discovered are left out.
+func (fp *FriendPairModel) GetSuggestionsFor(userId, limit int) ([]*Suggestion, error) {
+	query := fmt.Sprintf(`
+		WITH RECURSIVE edges AS (
+			SELECT source_user_id AS user_id, destination_user_id AS friend_id FROM friends WHERE status = 'accepted'
+			UNION ALL
+			SELECT destination_user_id, source_user_id FROM friends WHERE status = 'accepted'
+		), reach (user_id, via, depth) AS (
+			SELECT e.friend_id, e.friend_id, 1
+			FROM edges e
+			INNER JOIN users v
+			ON v.id = e.friend_id
+			WHERE e.user_id = $1 AND v.suspended_at IS NULL AND v.deleted_at IS NULL
+			UNION ALL
+			SELECT e.friend_id, r.via, r.depth + 1
+			FROM reach r
+			INNER JOIN edges e
+			ON e.user_id = r.user_id
+			WHERE r.depth < 2
+		)
+		SELECT users.id, users.uuid, users.name, users.handle, users.avatar_url, count(DISTINCT reach.via)
+		FROM reach
+		INNER JOIN users
+		ON users.id = reach.user_id
+		WHERE reach.depth = 2 AND users.id != $1
+			AND users.discoverable AND users.suspended_at IS NULL AND users.deleted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM friends f
+				WHERE (f.source_user_id = $1 AND f.destination_user_id = users.id)
+					OR (f.source_user_id = users.id AND f.destination_user_id = $1)
+			)
+			AND %s
+		GROUP BY users.id
+		ORDER BY count(DISTINCT reach.via) DESC, users.id ASC
+		LIMIT $2`, notBlocked("users.id", "$1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := fp.db.QueryContext(ctx, query, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var u User
+		var suggestion Suggestion
+		err := rows.Scan(
+			&u.Id,
+			&u.Uuid,
+			&u.Name,
+			&u.Handle,
+			&u.AvatarUrl,
+			&suggestion.MutualCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		suggestion.User = &u
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
 func ValidateFriendPair(v *validator.Validator, friendRequest *FriendRequest) {
 	v.Check(friendRequest.SourceUserId > 0, "source_user_id", "must be valid")
 	v.Check(friendRequest.DestinationUserId > 0, "destination_user_id", "must be valid")
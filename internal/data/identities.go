@@ -0,0 +1,293 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("identity already linked")
+	ErrLastIdentity      = errors.New("cannot remove the last login method")
+)
+
+const ProviderEmail = "email"
+
+// Identity is a login method linked to a user: either an email and password or an
+// account with an OAuth provider, identified by the provider's subject.
+type Identity struct {
+	Id       int    `json:"id"`
+	UserId   int    `json:"-"`
+	Provider string `json:"provider"`
+	Subject  string `json:"-"`
+	Email    string `json:"email"`
+	LinkedAt string `json:"linked_at"`
+}
+
+type IdentityModel struct {
+	db *sql.DB
+}
+
+// NewEmailIdentity describes the email and password login of a user.
+func NewEmailIdentity(user *User) *Identity {
+	return &Identity{
+		UserId:   user.Id,
+		Provider: ProviderEmail,
+		Subject:  strconv.Itoa(user.Id),
+		Email:    user.Email,
+	}
+}
+
+func (m *IdentityModel) Insert(identity *Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	return insertIdentity(ctx, m.db, identity)
+}
+
+func insertIdentity(ctx context.Context, q queryRower, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, linked_at`
+
+	args := []interface{}{identity.UserId, identity.Provider, identity.Subject, identity.Email}
+
+	err := q.QueryRowContext(ctx, query, args...).Scan(&identity.Id, &identity.LinkedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "unique_provider_subject"`:
+			return ErrDuplicateIdentity
+		case err.Error() == `pq: duplicate key value violates unique constraint "unique_user_provider"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *IdentityModel) GetByProviderSubject(provider, subject string) (*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, linked_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var identity Identity
+
+	err := m.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.Id,
+		&identity.UserId,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LinkedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+// ClaimLegacy assigns a provider subject to an identity that was migrated from the
+// users table without one, matching it by email address.
+func (m *IdentityModel) ClaimLegacy(provider, subject, email string) (*Identity, error) {
+	query := `
+		UPDATE user_identities
+		SET subject = $2
+		WHERE id = (
+			SELECT id FROM user_identities
+			WHERE provider = $1 AND subject IS NULL AND email = $3
+			LIMIT 1
+		)
+		RETURNING id, user_id, provider, subject, email, linked_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var identity Identity
+
+	err := m.db.QueryRowContext(ctx, query, provider, subject, email).Scan(
+		&identity.Id,
+		&identity.UserId,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LinkedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+func (m *IdentityModel) GetAllForUser(userId int) ([]*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, COALESCE(subject, ''), email, linked_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY linked_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(
+			&identity.Id,
+			&identity.UserId,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.LinkedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// LinkEmail links an email identity to the user and saves the password they
+// have just set for it, both in one transaction.
+func (m *IdentityModel) LinkEmail(user *User) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	identity := NewEmailIdentity(user)
+
+	err = insertIdentity(ctx, tx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE users
+		SET password = $2, version = version + 1, updated_at = now()
+		WHERE id = $1 AND version = $3
+		RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, user.Id, user.Password.hash, user.Version).Scan(&user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return identity, tx.Commit()
+}
+
+// Delete unlinks an identity from the user, refusing to remove their last one.
+// Unlinking the email identity also removes the user's password.
+func (m *IdentityModel) Delete(userId, identityId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the user's identities so that two concurrent unlinks can't both pass
+	// the check below.
+	query := `
+		SELECT id, provider
+		FROM user_identities
+		WHERE user_id = $1
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	provider := ""
+
+	for rows.Next() {
+		var id int
+		var p string
+
+		err = rows.Scan(&id, &p)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		count++
+		if id == identityId {
+			provider = p
+		}
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if provider == "" {
+		return ErrRecordNotFound
+	}
+
+	if count <= 1 {
+		return ErrLastIdentity
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_identities WHERE id = $1`, identityId)
+	if err != nil {
+		return err
+	}
+
+	if provider == ProviderEmail {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users
+			SET password = NULL, version = version + 1, updated_at = now()
+			WHERE id = $1`, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+var (
+	ErrOwnInvite      = errors.New("own invite")
+	ErrAlreadyFriends = errors.New("already friends")
+)
+
+// InviteCodeRX matches the codes of friend invites.
+var InviteCodeRX = regexp.MustCompile("^[a-z2-7]{16}$")
+
+const (
+	// DefaultInviteLifetime is how long an invite stays usable when its creator
+	// doesn't choose an expiry.
+	DefaultInviteLifetime = 7 * 24 * time.Hour
+	// MaxInviteLifetime is the furthest ahead an invite may expire.
+	MaxInviteLifetime = 30 * 24 * time.Hour
+	// MaxInviteUses caps the number of times a multi-use invite may be redeemed.
+	MaxInviteUses = 1000
+)
+
+// FriendInvite lets whoever holds its code become friends with the user that
+// created it, without a friend request. An invite without a maximum number of
+// uses can be redeemed until it expires or is revoked.
+type FriendInvite struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"-"`
+	Code      string     `json:"code"`
+	Link      string     `json:"link"`
+	MaxUses   *int       `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	Expiry    time.Time  `json:"expiry"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt string     `json:"created_at"`
+}
+
+type FriendInviteModel struct {
+	db *sql.DB
+}
+
+func generateInviteCode() (string, error) {
+	randomBytes := make([]byte, 10)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes)), nil
+}
+
+// New creates an invite from the user with a fresh code.
+func (m *FriendInviteModel) New(userId int, maxUses *int, expiry time.Time) (*FriendInvite, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &FriendInvite{
+		UserId:  userId,
+		Code:    code,
+		MaxUses: maxUses,
+		Expiry:  expiry,
+	}
+
+	err = m.Insert(invite)
+	return invite, err
+}
+
+func (m *FriendInviteModel) Insert(invite *FriendInvite) error {
+	query := `
+		INSERT INTO friend_invites (user_id, code, max_uses, expiry)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, uses, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, invite.UserId, invite.Code, invite.MaxUses, invite.Expiry).Scan(
+		&invite.Id,
+		&invite.Uses,
+		&invite.CreatedAt,
+	)
+}
+
+// usableInvite selects the invite with the code in $1 if it can still be
+// redeemed: it is unexpired, unrevoked, has uses left and its creator's account
+// is active.
+const usableInvite = `
+	SELECT fi.id, fi.user_id, fi.code, fi.max_uses, fi.uses, fi.expiry, fi.revoked_at, fi.created_at
+	FROM friend_invites fi
+	INNER JOIN users
+	ON users.id = fi.user_id
+	WHERE fi.code = $1
+		AND fi.revoked_at IS NULL AND fi.expiry > now()
+		AND (fi.max_uses IS NULL OR fi.uses < fi.max_uses)
+		AND users.suspended_at IS NULL AND users.deleted_at IS NULL`
+
+// GetUsable returns the invite with the code if it can still be redeemed.
+func (m *FriendInviteModel) GetUsable(code string) (*FriendInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	return scanInvite(m.db.QueryRowContext(ctx, usableInvite, code))
+}
+
+func scanInvite(row *sql.Row) (*FriendInvite, error) {
+	var invite FriendInvite
+
+	err := row.Scan(
+		&invite.Id,
+		&invite.UserId,
+		&invite.Code,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.Expiry,
+		&invite.RevokedAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invite, nil
+}
+
+// Redeem makes the user friends with the creator of the invite with the code,
+// accepting any friend request already pending between the two, and uses up one
+// of the invite's uses.
+func (m *FriendInviteModel) Redeem(code string, userId int) (*FriendInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invite, err := redeemInvite(ctx, tx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, tx.Commit()
+}
+
+func redeemInvite(ctx context.Context, tx *sql.Tx, code string, userId int) (*FriendInvite, error) {
+	invite, err := scanInvite(tx.QueryRowContext(ctx, usableInvite+` FOR UPDATE OF fi`, code))
+	if err != nil {
+		return nil, err
+	}
+
+	if invite.UserId == userId {
+		return nil, ErrOwnInvite
+	}
+
+	var blocked bool
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT NOT %s`, notBlocked("$1::bigint", "$2::bigint")), invite.UserId, userId).Scan(&blocked)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocked {
+		return nil, ErrBlocked
+	}
+
+	query := `
+		INSERT INTO friends (source_user_id, destination_user_id, status)
+		VALUES ($1, $2, 'accepted')
+		ON CONFLICT ON CONSTRAINT unique_friendship_pair DO UPDATE
+		SET status = 'accepted', updated_at = now(), version = friends.version + 1
+		WHERE friends.status != 'accepted'
+		RETURNING id`
+
+	var friendshipId int
+	err = tx.QueryRowContext(ctx, query, invite.UserId, userId).Scan(&friendshipId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAlreadyFriends
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE friend_invites SET uses = uses + 1 WHERE id = $1 RETURNING uses`, invite.Id).Scan(&invite.Uses)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// GetAllFor returns the invites the user created, including used up, expired and
+// revoked ones, newest first.
+func (m *FriendInviteModel) GetAllFor(userId int, filters Filters) ([]*FriendInvite, Meta, error) {
+	query := `
+		SELECT count(*) OVER(), id, user_id, code, max_uses, uses, expiry, revoked_at, created_at
+		FROM friend_invites
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId, filters.PageSize, filters.offset())
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	invites := []*FriendInvite{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var invite FriendInvite
+		err := rows.Scan(
+			&totalRecords,
+			&invite.Id,
+			&invite.UserId,
+			&invite.Code,
+			&invite.MaxUses,
+			&invite.Uses,
+			&invite.Expiry,
+			&invite.RevokedAt,
+			&invite.CreatedAt,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		invites = append(invites, &invite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return invites, meta, nil
+}
+
+// Revoke stops the user's invite from being redeemed again.
+func (m *FriendInviteModel) Revoke(userId, id int) error {
+	query := `
+		UPDATE friend_invites
+		SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateFriendInvite(v *validator.Validator, invite *FriendInvite) {
+	if invite.MaxUses != nil {
+		v.Check(*invite.MaxUses >= 0, "max_uses", "must not be negative")
+		v.Check(*invite.MaxUses <= MaxInviteUses, "max_uses", fmt.Sprintf("must not be more than %d", MaxInviteUses))
+	}
+
+	v.Check(invite.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(invite.Expiry.Before(time.Now().Add(MaxInviteLifetime)), "expiry", "must be within 30 days")
+}
+
+func ValidateInviteCode(v *validator.Validator, code string) {
+	v.Check(validator.Matches(code, InviteCodeRX), "code", "must be a valid invite code")
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/lockout"
+)
+
+// LoginAttemptModel keeps failed login counters in Postgres so that every
+// instance of the api sees the same counts. It implements lockout.Store.
+type LoginAttemptModel struct {
+	db *sql.DB
+}
+
+func (m *LoginAttemptModel) Get(key string, since time.Time) (lockout.Attempts, error) {
+	query := `
+		SELECT failures, last_failure_at
+		FROM login_attempts
+		WHERE key = $1 AND last_failure_at >= $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var attempts lockout.Attempts
+
+	err := m.db.QueryRowContext(ctx, query, key, since).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return lockout.Attempts{}, nil
+		}
+		return lockout.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+// Fail counts a failed attempt, starting over when the previous failure has been
+// forgotten. Forgotten counters of other keys are pruned along the way.
+func (m *LoginAttemptModel) Fail(key string, now, since time.Time) (lockout.Attempts, error) {
+	query := `
+		WITH pruned AS (
+			DELETE FROM login_attempts
+			WHERE last_failure_at < $3 AND key <> $1
+		)
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < $3 THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures, last_failure_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var attempts lockout.Attempts
+
+	err := m.db.QueryRowContext(ctx, query, key, now, since).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+func (m *LoginAttemptModel) Reset(key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+var ErrMFAEnabled = errors.New("two-factor authentication already enabled")
+
+// RecoveryCodeCount is the number of recovery codes generated at a time.
+const RecoveryCodeCount = 10
+
+// MFA is a user's TOTP second factor. It is enabled once the user has confirmed
+// the enrolment with a code from their authenticator app.
+type MFA struct {
+	UserId                 int    `json:"-"`
+	Secret                 string `json:"-"`
+	Enabled                bool   `json:"enabled"`
+	LastUsedStep           int64  `json:"-"`
+	RecoveryCodesRemaining int    `json:"recovery_codes_remaining"`
+}
+
+type MFAModel struct {
+	db *sql.DB
+}
+
+// NewRecoveryCodes generates a set of single-use recovery codes, returning them in
+// plaintext to show to the user once and hashed for storage.
+func NewRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([][]byte, RecoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case and separators so that
+// codes can be typed in however they were written down.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+func (m *MFAModel) Get(userId int) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at IS NOT NULL, last_used_step,
+			(SELECT count(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var mfa MFA
+
+	err := m.db.QueryRowContext(ctx, query, userId).Scan(
+		&mfa.UserId,
+		&mfa.Secret,
+		&mfa.Enabled,
+		&mfa.LastUsedStep,
+		&mfa.RecoveryCodesRemaining,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// Enroll stores a new secret for the user, replacing any enrolment that hasn't
+// been confirmed yet.
+func (m *MFAModel) Enroll(userId int, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE user_mfa.confirmed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, userId, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrMFAEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables the user's pending enrolment with the step of the code that
+// confirmed it, and stores their recovery codes.
+func (m *MFAModel) Confirm(userId int, step int64, recoveryCodes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_mfa
+		SET confirmed_at = now(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userId, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that a code for the given step was accepted. Codes are only
+// accepted for steps after the last one used, so a code can't be replayed.
+func (m *MFAModel) UseStep(userId int, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's unused recovery codes as used.
+func (m *MFAModel) UseRecoveryCode(userId int, code string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, userId, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes in favour of new ones.
+func (m *MFAModel) ReplaceRecoveryCodes(userId int, recoveryCodes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userId, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, recoveryCodes [][]byte) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete disables two-factor authentication for the user.
+func (m *MFAModel) Delete(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -12,16 +13,48 @@ var (
 	QueryTimeout      = 5 * time.Second
 )
 
+// queryRower is satisfied by both *sql.DB and *sql.Tx, so that a statement can
+// run on its own or as part of a transaction.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Users     UserModel
-	Friends   FriendPairModel
-	FreeTimes FreeTimeModel
+	Users         UserModel
+	Friends       FriendPairModel
+	FreeTimes     FreeTimeModel
+	RefreshTokens RefreshTokenModel
+	Tokens        *TokenStore
+	OneTimeTokens OneTimeTokenModel
+	Identities    IdentityModel
+	MFA           MFAModel
+	APIKeys       APIKeyModel
+	Sessions      SessionModel
+	LoginAttempts LoginAttemptModel
+	AdminActions  AdminActionModel
+	DataExports   DataExportModel
+	Blocks        BlockModel
+	FriendGroups  FriendGroupModel
+	FriendInvites FriendInviteModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:     UserModel{db: db},
-		Friends:   FriendPairModel{db: db},
-		FreeTimes: FreeTimeModel{db: db},
+		Users:         UserModel{db: db},
+		Friends:       FriendPairModel{db: db},
+		FreeTimes:     FreeTimeModel{db: db},
+		RefreshTokens: RefreshTokenModel{db: db},
+		Tokens:        NewTokenStore(db),
+		OneTimeTokens: OneTimeTokenModel{db: db},
+		Identities:    IdentityModel{db: db},
+		MFA:           MFAModel{db: db},
+		APIKeys:       APIKeyModel{db: db},
+		Sessions:      SessionModel{db: db},
+		LoginAttempts: LoginAttemptModel{db: db},
+		AdminActions:  AdminActionModel{db: db},
+		DataExports:   DataExportModel{db: db},
+		Blocks:        BlockModel{db: db},
+		FriendGroups:  FriendGroupModel{db: db},
+		FriendInvites: FriendInviteModel{db: db},
 	}
 }
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"time"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+const (
+	PurposeActivation    = "activation"
+	PurposePasswordReset = "password-reset"
+	PurposeRestore       = "restore"
+	PurposeDataExport    = "data-export"
+)
+
+// OneTimeToken is a random, single-use token that is emailed to a user to prove
+// that they control their address. Only the SHA-256 hash of the token is stored.
+type OneTimeToken struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserId    int       `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Purpose   string    `json:"-"`
+}
+
+type OneTimeTokenModel struct {
+	db *sql.DB
+}
+
+func generateOneTimeToken(userId int, ttl time.Duration, purpose string) (*OneTimeToken, error) {
+	token := &OneTimeToken{
+		UserId:  userId,
+		Expiry:  time.Now().Add(ttl),
+		Purpose: purpose,
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func (m *OneTimeTokenModel) New(userId int, ttl time.Duration, purpose string) (*OneTimeToken, error) {
+	token, err := generateOneTimeToken(userId, ttl, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m *OneTimeTokenModel) Insert(token *OneTimeToken) error {
+	query := `
+		INSERT INTO one_time_tokens (hash, user_id, expiry, purpose)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, token.Hash, token.UserId, token.Expiry, token.Purpose)
+	return err
+}
+
+func (m *OneTimeTokenModel) DeleteAllForUser(purpose string, userId int) error {
+	query := `
+		DELETE FROM one_time_tokens
+		WHERE purpose = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, purpose, userId)
+	return err
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type RefreshToken struct {
+	Jti      string
+	FamilyId string
+	UserId   int
+	Expiry   time.Time
+	Used     bool
+	Revoked  bool
+}
+
+type RefreshTokenModel struct {
+	db *sql.DB
+}
+
+// NewFamily starts a new refresh token family for the user. Every refresh token
+// obtained by rotating a token from this family belongs to the same family.
+func (m *RefreshTokenModel) NewFamily(userId int) (string, error) {
+	query := `
+		INSERT INTO token_families (user_id)
+		VALUES ($1)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var familyId string
+
+	err := m.db.QueryRowContext(ctx, query, userId).Scan(&familyId)
+	if err != nil {
+		return "", err
+	}
+
+	return familyId, nil
+}
+
+func (m *RefreshTokenModel) Insert(token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (jti, family_id, user_id, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, token.Jti, token.FamilyId, token.UserId, token.Expiry)
+	return err
+}
+
+func (m *RefreshTokenModel) Get(jti string) (*RefreshToken, error) {
+	query := `
+		SELECT rt.jti, rt.family_id, rt.user_id, rt.expiry, rt.used_at IS NOT NULL, tf.revoked_at IS NOT NULL
+		FROM refresh_tokens rt
+		INNER JOIN token_families tf
+		ON tf.id = rt.family_id
+		WHERE rt.jti = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var token RefreshToken
+
+	err := m.db.QueryRowContext(ctx, query, jti).Scan(
+		&token.Jti,
+		&token.FamilyId,
+		&token.UserId,
+		&token.Expiry,
+		&token.Used,
+		&token.Revoked,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkUsed flags the refresh token as exchanged. It returns ErrEditConflict when the
+// token had already been used, which callers should treat as token reuse.
+func (m *RefreshTokenModel) MarkUsed(token *RefreshToken) error {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = now()
+		WHERE jti = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, token.Jti)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	token.Used = true
+
+	return nil
+}
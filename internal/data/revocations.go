@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// revocationCacheTTL is how long the in-process revocation cache is trusted before
+// it is reloaded from the database. It bounds how long a token revoked by another
+// instance of the api can keep being accepted by this one.
+var revocationCacheTTL = time.Minute
+
+// TokenStore keeps track of revoked tokens. Revocations are persisted in Postgres and
+// mirrored in an in-process cache so that checking a token on every request does
+// not require a database round trip.
+type TokenStore struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	loadedAt time.Time
+	jtis     map[string]time.Time
+	families map[string]struct{}
+	cutoffs  map[int]time.Time
+}
+
+func NewTokenStore(db *sql.DB) *TokenStore {
+	return &TokenStore{
+		db:       db,
+		jtis:     make(map[string]time.Time),
+		families: make(map[string]struct{}),
+		cutoffs:  make(map[int]time.Time),
+	}
+}
+
+// RevokeToken revokes a single token identified by its jti claim until it expires.
+func (s *TokenStore) RevokeToken(userId int, jti string, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (user_id, jti, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userId, jti, expiry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.jtis[jti] = expiry
+	s.mu.Unlock()
+
+	return nil
+}
+
+// UseToken marks a single-use token identified by its jti claim as used until it
+// expires. It reports false when the token was used already.
+func (s *TokenStore) UseToken(userId int, jti string, expiry time.Time) (bool, error) {
+	query := `
+		INSERT INTO revoked_tokens (user_id, jti, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, query, userId, jti, expiry)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.jtis[jti] = expiry
+	s.mu.Unlock()
+
+	return rows == 1, nil
+}
+
+// IsTokenUsed reports whether a single-use token was used or revoked. Unlike
+// IsRevoked it always asks the database, so that a token used with another
+// instance of the api is caught right away.
+func (s *TokenStore) IsTokenUsed(jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var used bool
+	err := s.db.QueryRowContext(ctx, query, jti).Scan(&used)
+	return used, err
+}
+
+// RevokeFamily revokes every access and refresh token issued for a token family,
+// i.e. a single login.
+func (s *TokenStore) RevokeFamily(familyId string) error {
+	query := `
+		UPDATE token_families
+		SET revoked_at = now()
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, familyId)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.families[familyId] = struct{}{}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeAllForUser revokes every token issued to the user up until now and all of
+// their token families, logging them out everywhere.
+func (s *TokenStore) RevokeAllForUser(userId int) error {
+	// Postgres keeps microseconds, so the cached cutoff is kept to the same precision
+	cutoff := time.Now().Truncate(time.Microsecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	insertCutoffQuery := `
+		INSERT INTO revoked_tokens (user_id, issued_before, expiry)
+		VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, insertCutoffQuery, userId, cutoff, cutoff.Add(AccessTokenTTL))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	revokeFamiliesQuery := `
+		UPDATE token_families
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err = tx.ExecContext(ctx, revokeFamiliesQuery, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if cutoff.After(s.cutoffs[userId]) {
+		s.cutoffs[userId] = cutoff
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked reports whether the access token described by claims has been revoked,
+// either by itself, through its token family or by a logout of every session.
+func (s *TokenStore) IsRevoked(userId int, claims *UserClaims) (bool, error) {
+	s.mu.RLock()
+	stale := time.Since(s.loadedAt) > revocationCacheTTL
+	s.mu.RUnlock()
+
+	if stale {
+		err := s.reload()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.jtis[claims.Jti]; ok && claims.Jti != "" {
+		return true, nil
+	}
+
+	if _, ok := s.families[claims.Family]; ok && claims.Family != "" {
+		return true, nil
+	}
+
+	if cutoff, ok := s.cutoffs[userId]; ok && claims.IssuedBefore(cutoff) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// reload replaces the cache with the revocations that can still affect a live
+// access token and prunes the ones that have expired.
+func (s *TokenStore) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expiry < now()`)
+	if err != nil {
+		return err
+	}
+
+	jtis := make(map[string]time.Time)
+	families := make(map[string]struct{})
+	cutoffs := make(map[int]time.Time)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, jti, issued_before, expiry
+		FROM revoked_tokens`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int
+		var jti sql.NullString
+		var issuedBefore sql.NullTime
+		var expiry time.Time
+
+		err = rows.Scan(&userId, &jti, &issuedBefore, &expiry)
+		if err != nil {
+			return err
+		}
+
+		if jti.Valid {
+			jtis[jti.String] = expiry
+		}
+
+		if issuedBefore.Valid && issuedBefore.Time.After(cutoffs[userId]) {
+			cutoffs[userId] = issuedBefore.Time
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	familyRows, err := s.db.QueryContext(ctx, `
+		SELECT id
+		FROM token_families
+		WHERE revoked_at > $1`, time.Now().Add(-AccessTokenTTL))
+	if err != nil {
+		return err
+	}
+	defer familyRows.Close()
+
+	for familyRows.Next() {
+		var familyId string
+
+		err = familyRows.Scan(&familyId)
+		if err != nil {
+			return err
+		}
+
+		families[familyId] = struct{}{}
+	}
+
+	if err = familyRows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.jtis = jtis
+	s.families = families
+	s.cutoffs = cutoffs
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenStoreIsRevokedCutoff(t *testing.T) {
+	t.Parallel()
+
+	cutoff := time.Unix(1700000000, 500000000)
+
+	// a freshly loaded cache doesn't go to the database
+	s := NewTokenStore(nil)
+	s.loadedAt = time.Now()
+	s.cutoffs[1] = cutoff
+
+	tests := []struct {
+		name   string
+		userId int
+		iat    time.Time
+		micro  bool
+		want   bool
+	}{
+		{name: "earlier second", userId: 1, iat: cutoff.Add(-time.Second), micro: true, want: true},
+		{name: "same second before cutoff", userId: 1, iat: cutoff.Add(-time.Millisecond), micro: true, want: true},
+		{name: "at cutoff", userId: 1, iat: cutoff, micro: true, want: true},
+		{name: "same second after cutoff", userId: 1, iat: cutoff.Add(time.Microsecond), micro: true, want: false},
+		{name: "later second", userId: 1, iat: cutoff.Add(time.Second), micro: true, want: false},
+		{name: "seconds only, same second", userId: 1, iat: cutoff.Add(200 * time.Millisecond), want: true},
+		{name: "seconds only, later second", userId: 1, iat: cutoff.Add(time.Second), want: false},
+		{name: "other user", userId: 2, iat: cutoff.Add(-time.Second), micro: true, want: false},
+	}
+
+	for _, tt := range tests {
+		claims := &UserClaims{
+			StandardClaims: StandardClaims{
+				// iat only survives a round trip through a token to the second
+				Iat: jwt.NumericDate{Time: tt.iat.Truncate(time.Second)},
+			},
+		}
+		if tt.micro {
+			claims.IatMicro = tt.iat.UnixMicro()
+		}
+
+		got, err := s.IsRevoked(tt.userId, claims)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got revoked %t; want %t", tt.name, got, tt.want)
+		}
+	}
+}
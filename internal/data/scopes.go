@@ -0,0 +1,70 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+// Scopes limit what a credential may be used for.
+const (
+	ScopeProfileRead    = "profile:read"
+	ScopeProfileWrite   = "profile:write"
+	ScopeFriendsRead    = "friends:read"
+	ScopeFriendsWrite   = "friends:write"
+	ScopeFreeTimesRead  = "freetimes:read"
+	ScopeFreeTimesWrite = "freetimes:write"
+
+	// ScopeAccount allows managing the account itself, e.g. its password, login
+	// methods and API keys. It is only held by users that logged in interactively.
+	ScopeAccount = "account"
+)
+
+// GrantableScopes are the scopes that can be given to API keys.
+var GrantableScopes = []string{
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeFriendsRead,
+	ScopeFriendsWrite,
+	ScopeFreeTimesRead,
+	ScopeFreeTimesWrite,
+}
+
+// DefaultScopes are held by users that log in without asking for fewer scopes.
+func DefaultScopes() []string {
+	scopes := make([]string, 0, len(GrantableScopes)+1)
+	scopes = append(scopes, GrantableScopes...)
+	return append(scopes, ScopeAccount)
+}
+
+// ParseScope splits a space-separated scope parameter, as used in OAuth 2.0 and
+// in the scope claim of tokens.
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// ValidateScopes checks that scopes is a non-empty subset of allowed.
+func ValidateScopes(v *validator.Validator, key string, scopes []string, allowed []string) {
+	v.Check(len(scopes) > 0, key, "must contain at least one scope")
+	v.Check(validator.Unique(scopes), key, "must not contain duplicate values")
+	for _, scope := range scopes {
+		if !validator.In(scope, allowed...) {
+			v.AddError(key, "must only contain "+strings.Join(allowed, ", "))
+			return
+		}
+	}
+}
+
+// HasScope reports whether scopes include scope.
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
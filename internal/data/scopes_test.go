@@ -0,0 +1,102 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+func TestParseScope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{scope: "", want: nil},
+		{scope: "   ", want: nil},
+		{scope: "profile:read", want: []string{"profile:read"}},
+		{scope: "profile:read friends:write", want: []string{"profile:read", "friends:write"}},
+		{scope: "  profile:read \t friends:write  ", want: []string{"profile:read", "friends:write"}},
+	}
+
+	for _, tt := range tests {
+		got := ParseScope(tt.scope)
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseScope(%q): got %q; want %q", tt.scope, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseScope(%q): got %q; want %q", tt.scope, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{scopes: DefaultScopes(), scope: ScopeAccount, want: true},
+		{scopes: GrantableScopes, scope: ScopeAccount, want: false},
+		{scopes: GrantableScopes, scope: ScopeFreeTimesWrite, want: true},
+		{scopes: []string{ScopeProfileRead}, scope: ScopeProfileWrite, want: false},
+		{scopes: []string{ScopeProfileRead}, scope: "profile", want: false},
+		{scopes: nil, scope: ScopeProfileRead, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := HasScope(tt.scopes, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q, %q): got %t; want %t", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		allowed []string
+		valid   bool
+	}{
+		{name: "single", scopes: []string{ScopeProfileRead}, allowed: GrantableScopes, valid: true},
+		{name: "all grantable", scopes: GrantableScopes, allowed: GrantableScopes, valid: true},
+		{name: "account when allowed", scopes: []string{ScopeAccount}, allowed: DefaultScopes(), valid: true},
+		{name: "empty", scopes: []string{}, allowed: GrantableScopes, valid: false},
+		{name: "duplicate", scopes: []string{ScopeProfileRead, ScopeProfileRead}, allowed: GrantableScopes, valid: false},
+		{name: "account when not allowed", scopes: []string{ScopeAccount}, allowed: GrantableScopes, valid: false},
+		{name: "unknown", scopes: []string{ScopeProfileRead, "admin"}, allowed: GrantableScopes, valid: false},
+		{name: "wrong case", scopes: []string{"Profile:Read"}, allowed: GrantableScopes, valid: false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateScopes(v, "scopes", tt.scopes, tt.allowed)
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: got valid %t; want %t (errors %v)", tt.name, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestDefaultScopes(t *testing.T) {
+	t.Parallel()
+
+	scopes := DefaultScopes()
+	if len(scopes) != len(GrantableScopes)+1 || !HasScope(scopes, ScopeAccount) {
+		t.Fatalf("DefaultScopes: got %q; want the grantable scopes and %s", scopes, ScopeAccount)
+	}
+
+	// the returned slice must not share GrantableScopes' backing array
+	scopes[0] = ScopeAccount
+	if GrantableScopes[0] == ScopeAccount {
+		t.Error("DefaultScopes: modifying the result changed GrantableScopes")
+	}
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// maxUserAgentLength bounds the user agent stored for a session.
+const maxUserAgentLength = 512
+
+// Session is a login on one device. It lasts as long as its refresh token family
+// is neither revoked nor expired, and is identified by the family's id.
+type Session struct {
+	Id         string `json:"id"`
+	UserId     int    `json:"-"`
+	Provider   string `json:"provider"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+type SessionModel struct {
+	db *sql.DB
+}
+
+func (m *SessionModel) Insert(session *Session) error {
+	query := `
+		INSERT INTO sessions (family_id, user_id, provider, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at, last_seen_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	if len(session.UserAgent) > maxUserAgentLength {
+		session.UserAgent = session.UserAgent[:maxUserAgentLength]
+	}
+
+	args := []interface{}{session.Id, session.UserId, session.Provider, session.UserAgent, session.IP}
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&session.CreatedAt, &session.LastSeenAt)
+}
+
+// GetAllForUser returns the user's active sessions, i.e. those whose family isn't
+// revoked and still has a refresh token that can be exchanged.
+func (m *SessionModel) GetAllForUser(userId int) ([]*Session, error) {
+	query := `
+		SELECT s.family_id, s.user_id, s.provider, s.user_agent, s.ip, s.created_at, s.last_seen_at
+		FROM sessions s
+		INNER JOIN token_families tf
+		ON tf.id = s.family_id
+		WHERE s.user_id = $1 AND tf.revoked_at IS NULL AND EXISTS (
+			SELECT 1 FROM refresh_tokens rt
+			WHERE rt.family_id = s.family_id AND rt.used_at IS NULL AND rt.expiry > now()
+		)
+		ORDER BY s.last_seen_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.Id,
+			&session.UserId,
+			&session.Provider,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (m *SessionModel) Get(userId int, id string) (*Session, error) {
+	query := `
+		SELECT family_id, user_id, provider, user_agent, ip, created_at, last_seen_at
+		FROM sessions
+		WHERE user_id = $1 AND family_id::text = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var session Session
+
+	err := m.db.QueryRowContext(ctx, query, userId, id).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.Provider,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// Touch records that the session was just used from the given device.
+func (m *SessionModel) Touch(id, userAgent, ip string) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = now(), user_agent = $2, ip = $3
+		WHERE family_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	_, err := m.db.ExecContext(ctx, query, id, userAgent, ip)
+	return err
+}
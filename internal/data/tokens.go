@@ -1,15 +1,27 @@
 package data
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"time"
 
+	"github.com/AustinMusiku/Materix-go/internal/signing"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	AccessTokenTTL  = 30 * time.Minute
+	RefreshTokenTTL = 24 * time.Hour
+	MFATokenTTL     = 5 * time.Minute
+
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+	tokenTypeMFA     = "mfa"
+)
+
 type UserClaims struct {
 	Uuid      string `json:"uuid"`
 	Username  string `json:"username"`
@@ -18,6 +30,10 @@ type UserClaims struct {
 	Provider  string `json:"provider"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
+	Family    string `json:"fam"`
+	Scope     string `json:"scope"`
+	// IatMicro is the issue time in microseconds, as iat only holds whole seconds.
+	IatMicro int64 `json:"iat_us,omitempty"`
 	StandardClaims
 }
 
@@ -28,9 +44,69 @@ type StandardClaims struct {
 	Aud string          `json:"aud"`
 	Nbf jwt.NumericDate `json:"nbf"`
 	Sub string          `json:"sub"`
+	Jti string          `json:"jti,omitempty"`
+	Typ string          `json:"typ"`
+}
+
+type RefreshClaims struct {
+	Family string `json:"fam"`
+	Scope  string `json:"scope"`
+	StandardClaims
+}
+
+type MFAClaims struct {
+	Provider string `json:"prv"`
+	Scope    string `json:"scope"`
+	StandardClaims
+}
+
+// Scopes returns the scopes granted by the access token. Tokens issued before
+// scopes were introduced hold the default scopes.
+func (c *UserClaims) Scopes() []string {
+	return scopesOrDefault(c.Scope)
+}
+
+// IssuedBefore reports whether the access token was issued at or before t. Tokens
+// issued before the claim carrying microseconds was added are only known to the
+// second, so any issued within the second of t count as issued before it.
+func (c *UserClaims) IssuedBefore(t time.Time) bool {
+	if c.IatMicro != 0 {
+		return !time.UnixMicro(c.IatMicro).After(t)
+	}
+	return !c.Iat.After(t.Truncate(time.Second))
+}
+
+func (c *RefreshClaims) Scopes() []string {
+	return scopesOrDefault(c.Scope)
+}
+
+func (c *MFAClaims) Scopes() []string {
+	return scopesOrDefault(c.Scope)
+}
+
+func scopesOrDefault(scope string) []string {
+	if scope == "" {
+		return DefaultScopes()
+	}
+	return ParseScope(scope)
 }
 
-func NewTokenPair(user User) (map[string]string, error) {
+// NewTokenPair signs an access token and a refresh token for the user with the key
+// set's signing key. Both tokens belong to the given token family and grant the
+// given scopes. The refresh token is returned alongside the pair so that the
+// caller can persist it.
+func NewTokenPair(keys *signing.KeySet, user User, family string, scopes []string) (map[string]string, *RefreshToken, error) {
+	accessJti, err := newJti()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshJti, err := newJti()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
 	claims := UserClaims{
 		Uuid:      user.Uuid,
 		Username:  user.Name,
@@ -39,34 +115,53 @@ func NewTokenPair(user User) (map[string]string, error) {
 		Provider:  user.Provider,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
+		Family:    family,
+		Scope:     FormatScope(scopes),
+		IatMicro:  now.UnixMicro(),
 		StandardClaims: StandardClaims{
-			Iat: jwt.NumericDate{Time: time.Now()},
-			Exp: jwt.NumericDate{Time: time.Now().Add(time.Minute * 30)},
+			Iat: jwt.NumericDate{Time: now},
+			Exp: jwt.NumericDate{Time: time.Now().Add(AccessTokenTTL)},
 			Iss: "https://materix.app",
 			Aud: "materix",
 			Sub: fmt.Sprintf("%d", user.Id),
 			Nbf: jwt.NumericDate{Time: time.Now()},
+			Jti: accessJti,
 		},
 	}
 
-	at, err := NewAccessToken(claims)
+	at, err := NewAccessToken(keys, claims)
 	if err != nil {
-		return map[string]string{}, err
+		return nil, nil, err
 	}
 
-	rt, err := NewRefreshToken(claims.StandardClaims)
+	refreshClaims := RefreshClaims{
+		Family:         family,
+		Scope:          claims.Scope,
+		StandardClaims: claims.StandardClaims,
+	}
+	refreshClaims.Jti = refreshJti
+	refreshClaims.Exp = jwt.NumericDate{Time: time.Now().Add(RefreshTokenTTL)}
+
+	rt, err := NewRefreshToken(keys, refreshClaims)
 	if err != nil {
-		return map[string]string{}, err
+		return nil, nil, err
+	}
+
+	refreshToken := &RefreshToken{
+		Jti:      refreshJti,
+		FamilyId: family,
+		UserId:   user.Id,
+		Expiry:   refreshClaims.Exp.Time,
 	}
 
 	return map[string]string{
 		"access_token":  at,
 		"refresh_token": rt,
-	}, nil
+	}, refreshToken, nil
 }
 
-func NewAccessToken(claims UserClaims) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func NewAccessToken(keys *signing.KeySet, claims UserClaims) (string, error) {
+	at, err := keys.Sign(jwt.MapClaims{
 		"uuid":      claims.Uuid,
 		"username":  claims.Username,
 		"email":     claims.Email,
@@ -74,16 +169,19 @@ func NewAccessToken(claims UserClaims) (string, error) {
 		"provider":  claims.Provider,
 		"createdAt": claims.CreatedAt,
 		"updatedAt": claims.UpdatedAt,
+		"fam":       claims.Family,
+		"scope":     claims.Scope,
 		// std claims
-		"sub": claims.Sub,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(time.Minute * 30).Unix(),
-		"aud": "materix",
-		"iss": "https://materix.app",
-		"nbf": time.Now().Unix(),
+		"sub":    claims.Sub,
+		"jti":    claims.Jti,
+		"iat":    claims.Iat.Unix(),
+		"iat_us": claims.IatMicro,
+		"exp":    time.Now().Add(AccessTokenTTL).Unix(),
+		"aud":    "materix",
+		"iss":    "https://materix.app",
+		"nbf":    time.Now().Unix(),
+		"typ":    tokenTypeAccess,
 	})
-
-	at, err := t.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
 		return "", fmt.Errorf("error signing access token: %w", err)
 	}
@@ -91,17 +189,19 @@ func NewAccessToken(claims UserClaims) (string, error) {
 	return at, nil
 }
 
-func NewRefreshToken(claims StandardClaims) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iat": claims.Iat,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-		"aud": "materix",
-		"iss": "https://materix.app",
-		"sub": claims.Sub,
-		"nbf": claims.Nbf,
+func NewRefreshToken(keys *signing.KeySet, claims RefreshClaims) (string, error) {
+	rt, err := keys.Sign(jwt.MapClaims{
+		"iat":   claims.Iat,
+		"exp":   claims.Exp,
+		"aud":   "materix",
+		"iss":   "https://materix.app",
+		"sub":   claims.Sub,
+		"nbf":   claims.Nbf,
+		"jti":   claims.Jti,
+		"fam":   claims.Family,
+		"scope": claims.Scope,
+		"typ":   tokenTypeRefresh,
 	})
-
-	rt, err := t.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
 		return "", fmt.Errorf("error signing refresh token: %w", err)
 	}
@@ -109,73 +209,104 @@ func NewRefreshToken(claims StandardClaims) (string, error) {
 	return rt, nil
 }
 
-func ParseAccessToken(at string) (*UserClaims, error) {
-	pat, err := jwt.ParseWithClaims(at, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func ParseAccessToken(keys *signing.KeySet, at string) (*UserClaims, error) {
+	pat, err := keys.Parse(at, &UserClaims{})
 	if err != nil {
 		return nil, err
 	}
 
 	claims, ok := pat.Claims.(*UserClaims)
-	if !ok {
+	if !ok || claims.Typ != tokenTypeAccess {
 		return nil, errors.New("invalid access token")
 	}
 
 	return claims, nil
 }
 
-func ParseRefreshToken(rt string) (*StandardClaims, error) {
-	rat, err := jwt.ParseWithClaims(rt, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func ParseRefreshToken(keys *signing.KeySet, rt string) (*RefreshClaims, error) {
+	rat, err := keys.Parse(rt, &RefreshClaims{})
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := rat.Claims.(*UserClaims)
-	if !ok {
+	claims, ok := rat.Claims.(*RefreshClaims)
+	if !ok || claims.Typ != tokenTypeRefresh || claims.Jti == "" || claims.Family == "" {
 		return nil, errors.New("invalid refresh token")
 	}
 
-	return &StandardClaims{
-		Iat: claims.Iat,
-		Exp: claims.Exp,
-		Iss: claims.Iss,
-		Aud: claims.Aud,
-		Nbf: claims.Nbf,
-		Sub: claims.Sub,
-	}, nil
+	return claims, nil
+}
+
+// NewMFAToken signs the short-lived token that a user who passed the first login
+// step presents together with their second factor. It carries the provider that
+// the first step was made with and the scopes that the login asked for.
+func NewMFAToken(keys *signing.KeySet, userId int, provider string, scopes []string) (string, error) {
+	jti, err := newJti()
+	if err != nil {
+		return "", err
+	}
+
+	mt, err := keys.Sign(jwt.MapClaims{
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(MFATokenTTL).Unix(),
+		"aud":   "materix",
+		"iss":   "https://materix.app",
+		"sub":   strconv.Itoa(userId),
+		"nbf":   time.Now().Unix(),
+		"jti":   jti,
+		"prv":   provider,
+		"scope": FormatScope(scopes),
+		"typ":   tokenTypeMFA,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error signing mfa token: %w", err)
+	}
+
+	return mt, nil
 }
 
-func (u *UserClaims) GetExpirationTime() (*jwt.NumericDate, error) {
+func ParseMFAToken(keys *signing.KeySet, mt string) (*MFAClaims, error) {
+	pmt, err := keys.Parse(mt, &MFAClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := pmt.Claims.(*MFAClaims)
+	if !ok || claims.Typ != tokenTypeMFA {
+		return nil, errors.New("invalid mfa token")
+	}
+
+	return claims, nil
+}
+
+func (u *StandardClaims) GetExpirationTime() (*jwt.NumericDate, error) {
 	return &u.Exp, nil
 }
 
-func (u *UserClaims) GetIssuedAt() (*jwt.NumericDate, error) {
+func (u *StandardClaims) GetIssuedAt() (*jwt.NumericDate, error) {
 	return &u.Iat, nil
 }
 
-func (u *UserClaims) GetNotBefore() (*jwt.NumericDate, error) {
+func (u *StandardClaims) GetNotBefore() (*jwt.NumericDate, error) {
 	return &u.Nbf, nil
 
 }
 
-func (u *UserClaims) GetIssuer() (string, error) {
+func (u *StandardClaims) GetIssuer() (string, error) {
 	return u.Iss, nil
 
 }
 
-func (u *UserClaims) GetSubject() (string, error) {
+func (u *StandardClaims) GetSubject() (string, error) {
 	return u.Sub, nil
 
 }
 
-func (u *UserClaims) GetAudience() (jwt.ClaimStrings, error) {
+func (u *StandardClaims) GetAudience() (jwt.ClaimStrings, error) {
 	return jwt.ClaimStrings{u.Aud}, nil
 }
 
-func (u *UserClaims) Verify() (bool, error) {
+func (u *StandardClaims) Verify() (bool, error) {
 	valid := true
 
 	valid = time.Now().After(u.Nbf.Time) && valid
@@ -191,3 +322,13 @@ func (u *UserClaims) Verify() (bool, error) {
 
 	return valid, nil
 }
+
+func newJti() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
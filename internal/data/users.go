@@ -2,28 +2,148 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/AustinMusiku/Materix-go/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var ErrDuplicateEmail = errors.New("email already exists")
+var (
+	ErrDuplicateEmail  = errors.New("email already exists")
+	ErrDuplicateHandle = errors.New("handle already exists")
+)
+
+// Roles grant access to the administration api. Every role includes the
+// permissions of the roles before it.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// HandleRX matches handles, which are how users find and mention each other.
+// Unlike display names they are unique, ignoring case.
+var HandleRX = regexp.MustCompile("^[a-zA-Z0-9_]{3,30}$")
+
+var uuidRX = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+// ReservedHandles can't be taken by users, either because they would be
+// mistaken for the service or clash with a route.
+var ReservedHandles = []string{
+	"admin", "administrator", "api", "auth", "help", "materix", "me", "mod",
+	"moderator", "null", "root", "settings", "staff", "support", "system",
+	"undefined", "user", "users",
+}
+
+// Visibilities of the parts of a user's profile.
+const (
+	VisibilityNobody   = "nobody"
+	VisibilityFriends  = "friends"
+	VisibilityEveryone = "everyone"
+)
+
+// PrivacySettings control who can find a user and what others see of them.
+type PrivacySettings struct {
+	// Discoverable users show up when others search for users.
+	Discoverable bool `json:"discoverable"`
+	// EmailVisibility is who sees the user's email address.
+	EmailVisibility string `json:"email_visibility"`
+	// ProfileVisibility is who can look the user up by id or handle.
+	ProfileVisibility string `json:"profile_visibility"`
+}
+
+// CanSee reports whether content with the given visibility is shown to a viewer,
+// who is either the owner themselves, one of their friends, or anyone else.
+func CanSee(visibility string, self, friend bool) bool {
+	switch visibility {
+	case VisibilityEveryone:
+		return true
+	case VisibilityFriends:
+		return self || friend
+	default:
+		return self
+	}
+}
+
+// emailVisibleTo returns an SQL expression giving the email of the users row
+// aliased table, or an empty string when the user whose id is in the parameter
+// viewer may not see it.
+func emailVisibleTo(table, viewer string) string {
+	return fmt.Sprintf(`CASE
+			WHEN %[1]s.id = %[2]s OR %[1]s.email_visibility = 'everyone' THEN %[1]s.email
+			WHEN %[1]s.email_visibility = 'friends' AND EXISTS (
+				SELECT 1 FROM friends vf
+				WHERE vf.status = 'accepted' AND (
+					(vf.source_user_id = %[1]s.id AND vf.destination_user_id = %[2]s) OR
+					(vf.source_user_id = %[2]s AND vf.destination_user_id = %[1]s))
+			) THEN %[1]s.email
+			ELSE ''
+		END`, table, viewer)
+}
+
+// emailMatches returns an SQL condition matching the users row aliased table when
+// the parameter q is exactly its email and the user whose id is in the parameter
+// viewer may see it. Searches match emails this way instead of through the search
+// column, so that a search can't confirm who owns a hidden address.
+func emailMatches(table, viewer, q string) string {
+	return fmt.Sprintf(`(%[1]s.email = %[3]s::citext AND %[2]s != '')`, table, emailVisibleTo(table, viewer), q)
+}
+
+// UserRestoreWindow is how long a deleted user can restore their account before
+// it is purged.
+const UserRestoreWindow = 30 * 24 * time.Hour
 
 type User struct {
-	Id        int      `json:"id"`
-	Uuid      string   `json:"uuid,omitempty"`
-	Name      string   `json:"user_name"`
-	Email     string   `json:"email"`
-	Password  password `json:"-"`
-	AvatarUrl string   `json:"avatar"`
-	Provider  string   `json:"provider,omitempty"`
-	CreatedAt string   `json:"created_at,omitempty"`
-	UpdatedAt string   `json:"updated_at,omitempty"`
-	Activated bool     `json:"activated,omitempty"`
-	Version   int      `json:"-"`
+	Id          int        `json:"id"`
+	Uuid        string     `json:"uuid,omitempty"`
+	Name        string     `json:"user_name"`
+	Handle      string     `json:"handle"`
+	Email       string     `json:"email"`
+	Password    password   `json:"-"`
+	AvatarUrl   string     `json:"avatar"`
+	Provider    string     `json:"provider,omitempty"`
+	CreatedAt   string     `json:"created_at,omitempty"`
+	UpdatedAt   string     `json:"updated_at,omitempty"`
+	Activated   bool       `json:"activated,omitempty"`
+	Role        string     `json:"-"`
+	SuspendedAt *time.Time `json:"-"`
+	DeletedAt   *time.Time `json:"-"`
+	Version     int        `json:"-"`
+}
+
+// UserAccount is a user along with the standing of their account, which is only
+// shown to the user themselves and to moderators.
+type UserAccount struct {
+	*User
+	Role        string     `json:"role"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+func (u *User) Account() *UserAccount {
+	return &UserAccount{
+		User:        u,
+		Role:        u.Role,
+		SuspendedAt: u.SuspendedAt,
+		DeletedAt:   u.DeletedAt,
+	}
+}
+
+// Accounts returns the accounts of the users.
+func Accounts(users []*User) []*UserAccount {
+	accounts := make([]*UserAccount, len(users))
+	for i, u := range users {
+		accounts[i] = u.Account()
+	}
+	return accounts
 }
 
 type password struct {
@@ -42,16 +162,56 @@ func NewUserModel(db *sql.DB) *UserModel {
 }
 
 func (u *UserModel) Insert(user *User) error {
-	query := `
-		INSERT INTO users (name, email, password, avatar_url, provider, activated)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, uuid, created_at, updated_at, version`
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
 
+	return insertUser(ctx, u.db, user)
+}
+
+// Register inserts a new user together with the identity made for them by
+// newIdentity, and redeems the friend invite with the code if one is given, all
+// in one transaction. An invite that can no longer be redeemed doesn't fail the
+// signup: it is skipped and the returned invite is nil.
+func (u *UserModel) Register(user *User, newIdentity func(*User) *Identity, inviteCode string) (*FriendInvite, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertIdentity(ctx, tx, newIdentity(user))
+	if err != nil {
+		return nil, err
+	}
+
+	var invite *FriendInvite
+	if inviteCode != "" {
+		invite, err = redeemInvite(ctx, tx, inviteCode, user.Id)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	return invite, tx.Commit()
+}
+
+func insertUser(ctx context.Context, q queryRower, user *User) error {
+	query := `
+		INSERT INTO users (name, handle, email, password, avatar_url, provider, activated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, uuid, created_at, updated_at, role, version`
+
 	args := []interface{}{
 		user.Name,
+		user.Handle,
 		user.Email,
 		user.Password.hash,
 		user.AvatarUrl,
@@ -59,17 +219,20 @@ func (u *UserModel) Insert(user *User) error {
 		user.Activated,
 	}
 
-	err := u.db.QueryRowContext(ctx, query, args...).Scan(
+	err := q.QueryRowContext(ctx, query, args...).Scan(
 		&user.Id,
 		&user.Uuid,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Role,
 		&user.Version,
 	)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_handle_key"`:
+			return ErrDuplicateHandle
 		default:
 			return err
 		}
@@ -78,9 +241,52 @@ func (u *UserModel) Insert(user *User) error {
 	return nil
 }
 
+// GetById returns the user unless they are suspended or deleted.
 func (u *UserModel) GetById(id int) (*User, error) {
 	query := `
-		SELECT id, uuid, name, email, password, provider, avatar_url, created_at, updated_at, activated, version
+		SELECT id, uuid, name, handle, email, password, provider, avatar_url, created_at, updated_at, activated, role, suspended_at, deleted_at, version
+		FROM users
+		WHERE id = $1 AND suspended_at IS NULL AND deleted_at IS NULL`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, id).Scan(
+		&user.Id,
+		&user.Uuid,
+		&user.Name,
+		&user.Handle,
+		&user.Email,
+		&user.Password.hash,
+		&user.Provider,
+		&user.AvatarUrl,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Activated,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &user, ErrRecordNotFound
+		default:
+			return &user, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAnyById returns the user whether or not they are suspended or deleted, e.g. to
+// tell them why they can't log in.
+func (u *UserModel) GetAnyById(id int) (*User, error) {
+	query := `
+		SELECT id, uuid, name, handle, email, password, provider, avatar_url, created_at, updated_at, activated, role, suspended_at, deleted_at, version
 		FROM users
 		WHERE id = $1`
 
@@ -93,6 +299,7 @@ func (u *UserModel) GetById(id int) (*User, error) {
 		&user.Id,
 		&user.Uuid,
 		&user.Name,
+		&user.Handle,
 		&user.Email,
 		&user.Password.hash,
 		&user.Provider,
@@ -100,6 +307,9 @@ func (u *UserModel) GetById(id int) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Activated,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -114,21 +324,35 @@ func (u *UserModel) GetById(id int) (*User, error) {
 	return &user, nil
 }
 
-func (u *UserModel) GetByName(name string) (*User, error) {
+// HandleTaken reports whether any user, including suspended and deleted ones,
+// has the handle, ignoring case.
+func (u *UserModel) HandleTaken(handle string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var taken bool
+	err := u.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE handle = $1)`, handle).Scan(&taken)
+	return taken, err
+}
+
+// GetByHandle returns the user with the handle, ignoring case, unless they are
+// suspended or deleted.
+func (u *UserModel) GetByHandle(handle string) (*User, error) {
 	query := `
-		SELECT id, uuid, name, email, password, provider, avatar_url, created_at, updated_at, activated, version
+		SELECT id, uuid, name, handle, email, password, provider, avatar_url, created_at, updated_at, activated, role, suspended_at, deleted_at, version
 		FROM users
-		WHERE name = $1`
+		WHERE handle = $1 AND suspended_at IS NULL AND deleted_at IS NULL`
 
 	var user User
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
-	err := u.db.QueryRowContext(ctx, query, name).Scan(
+	err := u.db.QueryRowContext(ctx, query, handle).Scan(
 		&user.Id,
 		&user.Uuid,
 		&user.Name,
+		&user.Handle,
 		&user.Email,
 		&user.Password.hash,
 		&user.Provider,
@@ -136,6 +360,9 @@ func (u *UserModel) GetByName(name string) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Activated,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -152,7 +379,7 @@ func (u *UserModel) GetByName(name string) (*User, error) {
 
 func (u *UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, uuid, name, email, password, provider, avatar_url, created_at, updated_at, activated, version
+		SELECT id, uuid, name, handle, email, password, provider, avatar_url, created_at, updated_at, activated, role, suspended_at, deleted_at, version
 		FROM users
 		WHERE email = $1`
 
@@ -165,6 +392,7 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 		&user.Id,
 		&user.Uuid,
 		&user.Name,
+		&user.Handle,
 		&user.Email,
 		&user.Password.hash,
 		&user.Provider,
@@ -172,6 +400,9 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Activated,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -186,10 +417,59 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetForToken returns the user that a valid, unexpired one-time token with the given
+// purpose was issued to.
+func (u *UserModel) GetForToken(purpose, tokenPlaintext string) (*User, error) {
+	query := `
+		SELECT users.id, users.uuid, users.name, users.handle, users.email, users.password, users.provider, users.avatar_url,
+			users.created_at, users.updated_at, users.activated, users.role, users.suspended_at, users.deleted_at, users.version
+		FROM users
+		INNER JOIN one_time_tokens
+		ON users.id = one_time_tokens.user_id
+		WHERE one_time_tokens.hash = $1
+			AND one_time_tokens.purpose = $2
+			AND one_time_tokens.expiry > $3`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, tokenHash[:], purpose, time.Now()).Scan(
+		&user.Id,
+		&user.Uuid,
+		&user.Name,
+		&user.Handle,
+		&user.Email,
+		&user.Password.hash,
+		&user.Provider,
+		&user.AvatarUrl,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Activated,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (u *UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET name = $2, email = $3, avatar_url = $4, activated = $5, provider = $6, version = version+1, updated_at = now()
+		SET name = $2, email = $3, avatar_url = $4, activated = $5, provider = $6, password = $8, handle = $9, version = version+1, updated_at = now()
 		WHERE id = $1 AND version = $7
 		RETURNING updated_at, version`
 
@@ -204,8 +484,65 @@ func (u *UserModel) Update(user *User) error {
 		user.Activated,
 		user.Provider,
 		user.Version,
+		user.Password.hash,
+		user.Handle,
+	}
+
+	err := u.db.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_handle_key"`:
+			return ErrDuplicateHandle
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SoftDelete marks the user as deleted. They can be restored until the restore
+// window has passed, after which PurgeDeleted deletes them for good.
+func (u *UserModel) SoftDelete(user *User) error {
+	query := `
+		UPDATE users
+		SET deleted_at = now(), version = version+1, updated_at = now()
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING deleted_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, user.Id, user.Version).Scan(&user.DeletedAt, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 
+	return nil
+}
+
+// Restore undoes the deletion of a user within the restore window.
+func (u *UserModel) Restore(user *User) error {
+	query := `
+		UPDATE users
+		SET deleted_at = NULL, version = version+1, updated_at = now()
+		WHERE id = $1 AND version = $2 AND deleted_at > $3
+		RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{user.Id, user.Version, time.Now().Add(-UserRestoreWindow)}
+
 	err := u.db.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt, &user.Version)
 	if err != nil {
 		switch {
@@ -216,9 +553,68 @@ func (u *UserModel) Update(user *User) error {
 		}
 	}
 
+	user.DeletedAt = nil
+
 	return nil
 }
 
+// PurgeDeleted permanently deletes the users whose restore window has passed,
+// together with their friends and free times, and returns the uuids of the users
+// deleted.
+func (u *UserModel) PurgeDeleted() ([]string, error) {
+	query := `
+		DELETE FROM users
+		WHERE deleted_at < $1
+		RETURNING uuid`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := u.db.QueryContext(ctx, query, time.Now().Add(-UserRestoreWindow))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uuids := []string{}
+
+	for rows.Next() {
+		var uuid string
+		err := rows.Scan(&uuid)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uuids, nil
+}
+
+// IsActive reports whether the user with the uuid exists and is neither
+// suspended nor deleted.
+func (u *UserModel) IsActive(uuid string) (bool, error) {
+	if !uuidRX.MatchString(uuid) {
+		return false, nil
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			WHERE uuid = $1 AND suspended_at IS NULL AND deleted_at IS NULL
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var active bool
+	err := u.db.QueryRowContext(ctx, query, uuid).Scan(&active)
+	return active, err
+}
+
 func (u *UserModel) Delete(id int) error {
 	query := `
 		DELETE FROM users
@@ -244,18 +640,193 @@ func (u *UserModel) Delete(id int) error {
 	return nil
 }
 
-func (u *UserModel) Search(q string, filters Filters) (*[]User, Meta, error) {
+// GetPrivacy returns the privacy settings of the user.
+func (u *UserModel) GetPrivacy(userId int) (*PrivacySettings, error) {
+	query := `
+		SELECT discoverable, email_visibility, profile_visibility
+		FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	var settings PrivacySettings
+
+	err := u.db.QueryRowContext(ctx, query, userId).Scan(
+		&settings.Discoverable,
+		&settings.EmailVisibility,
+		&settings.ProfileVisibility,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &settings, nil
+}
+
+// SetPrivacy replaces the privacy settings of the user.
+func (u *UserModel) SetPrivacy(userId int, settings *PrivacySettings) error {
+	query := `
+		UPDATE users
+		SET discoverable = $2, email_visibility = $3, profile_visibility = $4, updated_at = now()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	result, err := u.db.ExecContext(ctx, query, userId, settings.Discoverable, settings.EmailVisibility, settings.ProfileVisibility)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetRole changes the user's role.
+func (u *UserModel) SetRole(user *User, role string) error {
+	query := `
+		UPDATE users
+		SET role = $2, version = version+1, updated_at = now()
+		WHERE id = $1 AND version = $3
+		RETURNING role, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, user.Id, role, user.Version).Scan(&user.Role, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetSuspended suspends the user, or lifts their suspension. Suspended users can't
+// log in or use the api until they are unsuspended.
+func (u *UserModel) SetSuspended(user *User, suspended bool) error {
+	query := `
+		UPDATE users
+		SET suspended_at = CASE WHEN $2 THEN now() END, version = version+1, updated_at = now()
+		WHERE id = $1 AND version = $3
+		RETURNING suspended_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, user.Id, suspended, user.Version).Scan(&user.SuspendedAt, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAll lists users for administration. Every filter is optional: q matches the
+// name or email, role a single role and status is one of "active", "suspended",
+// "deleted" or "inactive" for users that haven't activated their account.
+func (u *UserModel) GetAll(q, role, status string, filters Filters) ([]*User, Meta, error) {
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, name, email, avatar_url
+		SELECT count(*) OVER(), id, uuid, name, handle, email, provider, avatar_url, created_at, updated_at, activated, role, suspended_at, deleted_at
 		FROM users
-		WHERE search @@ plainto_tsquery($1)
+		WHERE (search @@ plainto_tsquery($1) OR email = $1::citext OR $1 = '')
+			AND (role = $2 OR $2 = '')
+			AND CASE $3
+				WHEN 'active' THEN suspended_at IS NULL AND deleted_at IS NULL AND activated
+				WHEN 'suspended' THEN suspended_at IS NOT NULL
+				WHEN 'inactive' THEN NOT activated
+				WHEN 'deleted' THEN deleted_at IS NOT NULL
+				ELSE true
+			END
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{q, role, status, filters.limit(), filters.offset()}
+
+	rows, err := u.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.Id,
+			&user.Uuid,
+			&user.Name,
+			&user.Handle,
+			&user.Email,
+			&user.Provider,
+			&user.AvatarUrl,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Activated,
+			&user.Role,
+			&user.SuspendedAt,
+			&user.DeletedAt,
+		)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Meta{}, err
+	}
+
+	meta := calculateMeta(totalRecords, filters.Page, filters.PageSize)
+	return users, meta, nil
+}
+
+// Search returns the discoverable users matching q, as seen by the user with the
+// id viewerId, which is 0 for anonymous searches.
+func (u *UserModel) Search(q string, viewerId int, filters Filters) (*[]User, Meta, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, name, handle, %s, avatar_url
+		FROM users
+		WHERE (search @@ plainto_tsquery($1) OR %s) AND discoverable AND suspended_at IS NULL AND deleted_at IS NULL
+			AND %s
 		ORDER BY ts_rank(search, plainto_tsquery($1)), %s %s
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $2 OFFSET $3`, emailVisibleTo("users", "$4"), emailMatches("users", "$4", "$1"), notBlocked("users.id", "$4"), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
 	defer cancel()
 
-	args := []interface{}{q, filters.PageSize, filters.offset()}
+	args := []interface{}{q, filters.PageSize, filters.offset(), viewerId}
 
 	rows, err := u.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -273,6 +844,7 @@ func (u *UserModel) Search(q string, filters Filters) (*[]User, Meta, error) {
 			&totalRecords,
 			&user.Id,
 			&user.Name,
+			&user.Handle,
 			&user.Email,
 			&user.AvatarUrl,
 		)
@@ -299,7 +871,18 @@ func (p *password) Set(text string) error {
 	return nil
 }
 
+// IsSet reports whether the user has a password, which users who signed up
+// through an oauth provider may not.
+func (p *password) IsSet() bool {
+	return len(p.hash) > 0
+}
+
 func (p *password) Compare(text string) (bool, error) {
+	// Users who signed up through an oauth provider have no password to compare against
+	if len(p.hash) == 0 {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(text))
 	if err != nil {
 		switch {
@@ -312,6 +895,40 @@ func (p *password) Compare(text string) (bool, error) {
 	return true, nil
 }
 
+// HasRole reports whether the user's role is role or one that includes it.
+func (user *User) HasRole(role string) bool {
+	return roleRank(user.Role) >= roleRank(role)
+}
+
+// Outranks reports whether the user's role is above other's, which is required to
+// moderate other.
+func (user *User) Outranks(other *User) bool {
+	return roleRank(user.Role) > roleRank(other.Role)
+}
+
+// IsSuspended reports whether the user is currently suspended.
+func (user *User) IsSuspended() bool {
+	return user.SuspendedAt != nil
+}
+
+// IsDeleted reports whether the user deleted their account.
+func (user *User) IsDeleted() bool {
+	return user.DeletedAt != nil
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.In(role, Roles...), "role", "must be one of user, moderator or admin")
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -323,10 +940,53 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+func ValidatePrivacySettings(v *validator.Validator, settings *PrivacySettings) {
+	v.Check(validator.In(settings.EmailVisibility, VisibilityNobody, VisibilityFriends, VisibilityEveryone), "email_visibility", "must be one of nobody, friends or everyone")
+	v.Check(validator.In(settings.ProfileVisibility, VisibilityFriends, VisibilityEveryone), "profile_visibility", "must be one of friends or everyone")
+}
+
+func ValidateHandle(v *validator.Validator, handle string) {
+	v.Check(handle != "", "handle", "must be provided")
+	v.Check(validator.Matches(handle, HandleRX), "handle", "must be 3 to 30 letters, digits or underscores")
+	v.Check(!IsReservedHandle(handle), "handle", "is reserved")
+}
+
+// IsReservedHandle reports whether the handle is reserved, ignoring case.
+func IsReservedHandle(handle string) bool {
+	return validator.In(strings.ToLower(handle), ReservedHandles...)
+}
+
+// HandleFromName derives a handle from a name, dropping the characters a handle
+// can't hold. It returns an empty string when too little of the name is left.
+func HandleFromName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		case r == ' ', r == '.', r == '-':
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteRune('_')
+			}
+		}
+	}
+
+	handle := strings.Trim(b.String(), "_")
+	if len(handle) > 30 {
+		handle = strings.TrimRight(handle[:30], "_")
+	}
+	if len(handle) < 3 || IsReservedHandle(handle) {
+		return ""
+	}
+	return handle
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 
+	ValidateHandle(v, user.Handle)
+
 	ValidateEmail(v, user.Email)
 
 	if user.Password.plainText != nil {
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AustinMusiku/Materix-go/internal/validator"
+)
+
+func TestCanSee(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		visibility string
+		self       bool
+		friend     bool
+		want       bool
+	}{
+		{visibility: VisibilityEveryone, self: true, want: true},
+		{visibility: VisibilityEveryone, friend: true, want: true},
+		{visibility: VisibilityEveryone, want: true},
+		{visibility: VisibilityFriends, self: true, want: true},
+		{visibility: VisibilityFriends, friend: true, want: true},
+		{visibility: VisibilityFriends, want: false},
+		{visibility: VisibilityNobody, self: true, want: true},
+		{visibility: VisibilityNobody, friend: true, want: false},
+		{visibility: VisibilityNobody, want: false},
+		// unknown visibilities are treated as the most restrictive
+		{visibility: "", self: true, want: true},
+		{visibility: "", friend: true, want: false},
+		{visibility: "Everyone", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := CanSee(tt.visibility, tt.self, tt.friend); got != tt.want {
+			t.Errorf("CanSee(%q, self %t, friend %t): got %t; want %t", tt.visibility, tt.self, tt.friend, got, tt.want)
+		}
+	}
+}
+
+func TestHandleFromName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "jane", want: "jane"},
+		{name: "Jane Doe", want: "jane_doe"},
+		{name: "  Jane   Doe  ", want: "jane_doe"},
+		{name: "jane.doe-smith", want: "jane_doe_smith"},
+		{name: "jane_doe42", want: "jane_doe42"},
+		{name: "Ωmega", want: "mega"},
+		{name: strings.Repeat("a", 29) + " bcd", want: strings.Repeat("a", 29)},
+		{name: strings.Repeat("a", 40), want: strings.Repeat("a", 30)},
+		{name: "Zoë", want: ""},
+		{name: "jo", want: ""},
+		{name: "!!!", want: ""},
+		{name: "", want: ""},
+		{name: "Admin", want: ""},
+		{name: "SUPPORT", want: ""},
+		{name: "me.", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := HandleFromName(tt.name)
+		if got != tt.want {
+			t.Errorf("HandleFromName(%q): got %q; want %q", tt.name, got, tt.want)
+			continue
+		}
+
+		// whatever is derived must be a handle users could choose themselves
+		if got != "" {
+			v := validator.New()
+			if ValidateHandle(v, got); !v.Valid() {
+				t.Errorf("HandleFromName(%q): got invalid handle %q: %v", tt.name, got, v.Errors)
+			}
+		}
+	}
+}
+
+func TestValidateHandle(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		handle string
+		valid  bool
+	}{
+		{handle: "jane_doe", valid: true},
+		{handle: "Jane_Doe42", valid: true},
+		{handle: "abc", valid: true},
+		{handle: strings.Repeat("a", 30), valid: true},
+		{handle: "admins", valid: true},
+		{handle: "", valid: false},
+		{handle: "ab", valid: false},
+		{handle: strings.Repeat("a", 31), valid: false},
+		{handle: "jane-doe", valid: false},
+		{handle: "jane doe", valid: false},
+		{handle: "zoë", valid: false},
+		{handle: "admin", valid: false},
+		{handle: "Admin", valid: false},
+		{handle: "ROOT", valid: false},
+		{handle: "users", valid: false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateHandle(v, tt.handle)
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidateHandle(%q): got valid %t; want %t (errors %v)", tt.handle, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestIsReservedHandle(t *testing.T) {
+	t.Parallel()
+
+	for _, handle := range ReservedHandles {
+		for _, variant := range []string{handle, strings.ToUpper(handle), strings.ToUpper(handle[:1]) + handle[1:]} {
+			if !IsReservedHandle(variant) {
+				t.Errorf("IsReservedHandle(%q): got false; want true", variant)
+			}
+		}
+	}
+
+	for _, handle := range []string{"jane", "admins", "my_admin", "support1", ""} {
+		if IsReservedHandle(handle) {
+			t.Errorf("IsReservedHandle(%q): got true; want false", handle)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package imaging decodes uploaded images and prepares them for display, using
+// nothing but the standard library.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+
+	// Register the formats that uploads may use
+	_ "image/gif"
+	_ "image/png"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooManyPixels     = errors.New("image has too many pixels")
+)
+
+// Formats are the image formats that Decode accepts, as named by image.Decode.
+var Formats = []string{"jpeg", "png", "gif"}
+
+// Decode decodes a JPEG, PNG or GIF image, or the first frame of an animated
+// GIF. The dimensions are checked before the pixels are decoded, so a small file
+// claiming to hold an enormous image is rejected without being expanded.
+func Decode(data []byte, maxPixels int) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedFormat
+		}
+		return nil, "", err
+	}
+
+	supported := false
+	for _, f := range Formats {
+		supported = supported || f == format
+	}
+	if !supported {
+		return nil, "", ErrUnsupportedFormat
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return img, format, nil
+}
+
+// CropSquare returns the largest square in the centre of img.
+func CropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+
+	size := b.Dx()
+	if b.Dy() < size {
+		size = b.Dy()
+	}
+
+	x := b.Min.X + (b.Dx()-size)/2
+	y := b.Min.Y + (b.Dy()-size)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
+	return dst
+}
+
+// Resize scales img to width by height pixels with a triangle filter that widens
+// when shrinking, so every source pixel contributes to the result. An *image.RGBA
+// is read in place; other images are converted a row at a time rather than copied
+// whole.
+func Resize(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+
+	src, _ := img.(*image.RGBA)
+	var row *image.RGBA
+	if src == nil {
+		row = image.NewRGBA(image.Rect(0, 0, b.Dx(), 1))
+	}
+
+	// Scale the rows first, keeping intermediate values unrounded
+	xs := contributions(b.Dx(), width)
+	tmp := make([]float32, width*b.Dy()*4)
+	for y := 0; y < b.Dy(); y++ {
+		var pix []uint8
+		if src != nil {
+			pix = src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+		} else {
+			draw.Draw(row, row.Bounds(), img, image.Pt(b.Min.X, b.Min.Y+y), draw.Src)
+			pix = row.Pix
+		}
+
+		for x, c := range xs {
+			var px [4]float64
+			for i, w := range c.weights {
+				offset := (c.start + i) * 4
+				for ch := 0; ch < 4; ch++ {
+					px[ch] += w * float64(pix[offset+ch])
+				}
+			}
+
+			offset := (y*width + x) * 4
+			for ch := 0; ch < 4; ch++ {
+				tmp[offset+ch] = float32(px[ch])
+			}
+		}
+	}
+
+	ys := contributions(b.Dy(), height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range ys {
+		for x := 0; x < width; x++ {
+			var px [4]float64
+			for i, w := range c.weights {
+				offset := ((c.start+i)*width + x) * 4
+				for ch := 0; ch < 4; ch++ {
+					px[ch] += w * float64(tmp[offset+ch])
+				}
+			}
+
+			offset := y*dst.Stride + x*4
+			for ch := 0; ch < 4; ch++ {
+				dst.Pix[offset+ch] = clamp(px[ch])
+			}
+		}
+	}
+
+	return dst
+}
+
+// EncodeJPEG encodes img as a JPEG, flattening any transparency onto white.
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	b := img.Bounds()
+
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, flat, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// contribution holds the weights of the source pixels, starting at start, that
+// make up one destination pixel.
+type contribution struct {
+	start   int
+	weights []float64
+}
+
+func contributions(srcLen, dstLen int) []contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	radius := math.Max(scale, 1)
+
+	cs := make([]contribution, dstLen)
+	for i := range cs {
+		center := (float64(i) + 0.5) * scale
+
+		start := int(math.Floor(center - radius))
+		if start < 0 {
+			start = 0
+		}
+		end := int(math.Ceil(center + radius))
+		if end > srcLen {
+			end = srcLen
+		}
+
+		weights := make([]float64, 0, end-start)
+		sum := 0.0
+		for j := start; j < end; j++ {
+			w := 1 - math.Abs(float64(j)+0.5-center)/radius
+			if w < 0 {
+				w = 0
+			}
+			weights = append(weights, w)
+			sum += w
+		}
+
+		if sum == 0 {
+			// Only possible when enlarging by a lot; take the nearest pixel
+			nearest := int(center)
+			if nearest >= srcLen {
+				nearest = srcLen - 1
+			}
+			cs[i] = contribution{start: nearest, weights: []float64{1}}
+			continue
+		}
+
+		for j := range weights {
+			weights[j] /= sum
+		}
+		cs[i] = contribution{start: start, weights: weights}
+	}
+
+	return cs
+}
+
+func clamp(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	default:
+		return uint8(v + 0.5)
+	}
+}
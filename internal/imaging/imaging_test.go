@@ -0,0 +1,144 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	data := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 40, 30)))
+
+	img, format, err := Decode(data, 40*30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+		t.Errorf("got %s image of %v; want png image of 40x30", format, img.Bounds())
+	}
+
+	_, _, err = Decode(data, 40*30-1)
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("too many pixels: got %v; want ErrTooManyPixels", err)
+	}
+
+	_, _, err = Decode([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), 1000)
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("svg: got %v; want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestCropSquare(t *testing.T) {
+	t.Parallel()
+
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	// A 300x100 image with a blue square in the middle of red sides
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(100, 0, 200, 100), image.NewUniform(blue), image.Point{}, draw.Src)
+
+	got := CropSquare(img)
+
+	if got.Bounds() != image.Rect(0, 0, 100, 100) {
+		t.Fatalf("got bounds %v; want 100x100", got.Bounds())
+	}
+
+	for _, p := range []image.Point{{0, 0}, {99, 0}, {50, 50}, {0, 99}, {99, 99}} {
+		if c := got.RGBAAt(p.X, p.Y); c != blue {
+			t.Errorf("pixel %v: got %v; want %v", p, c, blue)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	c := color.RGBA{200, 100, 50, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	for _, size := range []int{64, 300, 512} {
+		got := Resize(img, size, size)
+
+		if got.Bounds() != image.Rect(0, 0, size, size) {
+			t.Fatalf("Resize to %d: got bounds %v", size, got.Bounds())
+		}
+
+		for _, p := range []image.Point{{0, 0}, {size / 2, size / 2}, {size - 1, size - 1}} {
+			if px := got.RGBAAt(p.X, p.Y); px != c {
+				t.Errorf("Resize to %d: pixel %v: got %v; want %v", size, p, px, c)
+			}
+		}
+	}
+
+	// Sub-images are read from their own bounds only
+	red := color.RGBA{255, 0, 0, 255}
+	halves := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(halves, image.Rect(0, 0, 100, 100), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(halves, image.Rect(100, 0, 200, 100), image.NewUniform(c), image.Point{}, draw.Src)
+
+	sub := Resize(halves.SubImage(image.Rect(100, 0, 200, 100)), 10, 10)
+	for _, p := range []image.Point{{0, 0}, {9, 9}} {
+		if px := sub.RGBAAt(p.X, p.Y); px != c {
+			t.Errorf("sub-image: pixel %v: got %v; want %v", p, px, c)
+		}
+	}
+
+	// Halving a checkerboard averages it to grey
+	checker := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+
+	px := Resize(checker, 16, 16).RGBAAt(8, 8)
+	if px.R < 120 || px.R > 136 || px.A != 255 {
+		t.Errorf("checkerboard: got %v; want a mid grey", px)
+	}
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	t.Parallel()
+
+	// Fully transparent pixels become white
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+
+	data, err := EncodeJPEG(img, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, g, b, _ := decoded.At(8, 8).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("got %d,%d,%d; want white", r>>8, g>>8, b>>8)
+	}
+}
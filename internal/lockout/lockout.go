@@ -0,0 +1,199 @@
+// Package lockout slows down password guessing by counting failed attempts per key,
+// such as an account or a client address, and making each key wait exponentially
+// longer after every failure until it is locked out for a while.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempts are the failed attempts recorded for a key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps the failure counters. Failures made before since are forgotten, so
+// counters reset on their own once a key stops failing for long enough.
+type Store interface {
+	// Get returns the failures recorded for key since the given time.
+	Get(key string, since time.Time) (Attempts, error)
+	// Fail records a failed attempt made at now and returns the updated counter.
+	Fail(key string, now, since time.Time) (Attempts, error)
+	// Reset forgets every failure recorded for key.
+	Reset(key string) error
+}
+
+// Policy describes how long a key has to wait after failing.
+type Policy struct {
+	// Free is the number of failures allowed before any delay applies.
+	Free int
+	// BaseDelay is the delay after the first failure past Free, doubling with
+	// every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Threshold is the number of failures that locks the key out for Lockout.
+	Threshold int
+	Lockout   time.Duration
+	// Window is how long failures are remembered for.
+	Window time.Duration
+}
+
+// Delay returns how long a key has to wait after its last failure.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.Threshold > 0 && failures >= p.Threshold {
+		return p.Lockout
+	}
+
+	if failures <= p.Free {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Free + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Guard applies a policy to the keys of one kind, e.g. accounts. Keys are stored
+// under the guard's name so that several guards can share a store.
+type Guard struct {
+	name   string
+	store  Store
+	policy Policy
+
+	// OnLockout is called with the key when it gets locked out.
+	OnLockout func(key string, until time.Time)
+
+	now func() time.Time
+}
+
+func NewGuard(name string, store Store, policy Policy) *Guard {
+	return &Guard{
+		name:   name,
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// Check returns how long key has to wait before it may try again, zero when it
+// may try right away.
+func (g *Guard) Check(key string) (time.Duration, error) {
+	now := g.now()
+
+	attempts, err := g.store.Get(g.storeKey(key), now.Add(-g.policy.Window))
+	if err != nil {
+		return 0, err
+	}
+
+	return g.retryAfter(attempts, now), nil
+}
+
+// Fail records a failed attempt for key and returns how long it now has to wait.
+func (g *Guard) Fail(key string) (time.Duration, error) {
+	now := g.now()
+
+	attempts, err := g.store.Fail(g.storeKey(key), now, now.Add(-g.policy.Window))
+	if err != nil {
+		return 0, err
+	}
+
+	retryAfter := g.retryAfter(attempts, now)
+
+	if attempts.Failures == g.policy.Threshold && g.OnLockout != nil {
+		g.OnLockout(key, now.Add(retryAfter))
+	}
+
+	return retryAfter, nil
+}
+
+// Reset clears the failures of key, e.g. after it succeeded.
+func (g *Guard) Reset(key string) error {
+	return g.store.Reset(g.storeKey(key))
+}
+
+func (g *Guard) retryAfter(attempts Attempts, now time.Time) time.Duration {
+	wait := attempts.LastFailure.Add(g.policy.Delay(attempts.Failures)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (g *Guard) storeKey(key string) string {
+	return g.name + ":" + key
+}
+
+// MemoryStore keeps the counters in process. It suits a single instance of the
+// api, every instance counts separately otherwise.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]Attempts
+	prunedAt  time.Time
+	pruneEach time.Duration
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts:  make(map[string]Attempts),
+		pruneEach: time.Minute,
+	}
+}
+
+func (s *MemoryStore) Get(key string, since time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || attempts.LastFailure.Before(since) {
+		return Attempts{}, nil
+	}
+
+	return attempts, nil
+}
+
+func (s *MemoryStore) Fail(key string, now, since time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.prunedAt) > s.pruneEach {
+		s.prune(since)
+		s.prunedAt = now
+	}
+
+	attempts := s.attempts[key]
+	if attempts.LastFailure.Before(since) {
+		attempts = Attempts{}
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	delete(s.attempts, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// prune drops the counters that have been forgotten so that the map doesn't grow
+// with every address that ever failed.
+func (s *MemoryStore) prune(since time.Time) {
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(since) {
+			delete(s.attempts, key)
+		}
+	}
+}
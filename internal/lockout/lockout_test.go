@@ -0,0 +1,132 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Free:      2,
+	BaseDelay: time.Second,
+	MaxDelay:  8 * time.Second,
+	Threshold: 8,
+	Lockout:   time.Hour,
+	Window:    24 * time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 5, want: 4 * time.Second},
+		{failures: 6, want: 8 * time.Second},
+		{failures: 7, want: 8 * time.Second},
+		{failures: 8, want: time.Hour},
+		{failures: 20, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := testPolicy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d): got %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	g := NewGuard("account", NewMemoryStore(), testPolicy)
+	g.now = func() time.Time { return now }
+
+	var lockedKey string
+	var lockedUntil time.Time
+	g.OnLockout = func(key string, until time.Time) {
+		lockedKey = key
+		lockedUntil = until
+	}
+
+	for i := 1; i <= 2; i++ {
+		wait, err := g.Fail("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Fatalf("failure %d: got wait %s; want none", i, wait)
+		}
+	}
+
+	wait, err := g.Fail("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != time.Second {
+		t.Fatalf("got wait %s; want 1s", wait)
+	}
+
+	if wait, _ := g.Check("bob"); wait != 0 {
+		t.Errorf("other key: got wait %s; want none", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if wait, _ := g.Check("alice"); wait != 500*time.Millisecond {
+		t.Errorf("got wait %s; want 500ms", wait)
+	}
+
+	for i := 4; i <= testPolicy.Threshold; i++ {
+		now = now.Add(testPolicy.MaxDelay)
+		if _, err := g.Fail("alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lockedKey != "alice" || !lockedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("OnLockout: got %q until %s; want %q until %s", lockedKey, lockedUntil, "alice", now.Add(time.Hour))
+	}
+
+	if err := g.Reset("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := g.Check("alice"); wait != 0 {
+		t.Errorf("after reset: got wait %s; want none", wait)
+	}
+}
+
+func TestMemoryStoreWindow(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Fail("ip:10.0.0.1", now, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	later := now.Add(2 * time.Hour)
+
+	attempts, err := s.Get("ip:10.0.0.1", later.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 0 {
+		t.Errorf("Get after window: got %d failures; want 0", attempts.Failures)
+	}
+
+	attempts, err = s.Fail("ip:10.0.0.1", later, later.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 1 {
+		t.Errorf("Fail after window: got %d failures; want 1", attempts.Failures)
+	}
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]any{
+		"name":            "Jane <Doe>",
+		"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	}
+
+	msg, err := render("user_welcome.tmpl", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg.subject != "Welcome to Materix!" {
+		t.Errorf("expected subject to be %q, but got %q", "Welcome to Materix!", msg.subject)
+	}
+
+	if !strings.Contains(msg.plainBody, "Jane <Doe>") {
+		t.Error("expected plain body to contain the unescaped name")
+	}
+
+	if !strings.Contains(msg.htmlBody, "Jane &lt;Doe&gt;") {
+		t.Error("expected html body to contain the escaped name")
+	}
+
+	if !strings.Contains(msg.plainBody, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		t.Error("expected plain body to contain the activation token")
+	}
+}
+
+func TestRenderMissingTemplate(t *testing.T) {
+	t.Parallel()
+
+	_, err := render("missing.tmpl", nil)
+	if err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	m := NewLogMailer(buf, "Materix <no-reply@materix.app>")
+
+	err := m.Send("jane@example.com", "user_welcome.tmpl", map[string]any{
+		"name":            "Jane",
+		"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+
+	for _, expected := range []string{
+		"From: Materix <no-reply@materix.app>\r\n",
+		"To: jane@example.com\r\n",
+		"Subject: Welcome to Materix!\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Type: text/html; charset=UTF-8",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected output to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package signing signs and verifies the JWTs issued by the application with a set
+// of keys identified by key id.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrUnknownKey is returned when a token was signed with a key that isn't in
+	// the key set, e.g. because it has been rotated out.
+	ErrUnknownKey = errors.New("signing: unknown signing key")
+	// ErrUnsupportedKey is returned for private keys other than RSA and Ed25519.
+	ErrUnsupportedKey = errors.New("signing: unsupported key type")
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing keys.
+const minRSABits = 2048
+
+// Key is a private key that tokens are signed with. Symmetric keys have no id and
+// aren't published.
+type Key struct {
+	ID      string
+	method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+// NewHMACKey returns an HS256 key for the shared secret.
+func NewHMACKey(secret []byte) *Key {
+	return &Key{method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// ParsePEM parses a PEM encoded RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8)
+// private key. The key id is the RFC 7638 thumbprint of the public key.
+func ParsePEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing: no PEM data found")
+	}
+
+	var private any
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block %q", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key *Key
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("signing: RSA keys must be at least %d bits", minRSABits)
+		}
+		key = &Key{method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}
+	case ed25519.PrivateKey:
+		key = &Key{method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+	}
+
+	key.ID = key.jwk().thumbprint()
+
+	return key, nil
+}
+
+// LoadPEMFile reads a private key from a PEM file.
+func LoadPEMFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// Algorithm returns the JWS algorithm of the key, e.g. "RS256".
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// KeySet signs tokens with its first key and verifies tokens signed with any of its
+// keys. Keys are rotated by adding the new key in front and keeping the old one
+// until every token signed with it has expired.
+type KeySet struct {
+	keys []*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("signing: no keys")
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k.ID] {
+			return nil, fmt.Errorf("signing: duplicate key %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+
+	return &KeySet{keys: keys}, nil
+}
+
+// Sign signs the claims with the set's signing key.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := s.keys[0]
+
+	t := jwt.NewWithClaims(key.method, claims)
+	if key.ID != "" {
+		t.Header["kid"] = key.ID
+	}
+
+	return t.SignedString(key.private)
+}
+
+// Parse verifies the signature of the token and decodes it into claims. The token
+// must have been signed by a key in the set with that key's algorithm.
+func (s *KeySet) Parse(token string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	methods := make([]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		methods = append(methods, k.method.Alg())
+	}
+	opts = append(opts, jwt.WithValidMethods(methods))
+
+	return jwt.ParseWithClaims(token, claims, s.keyfunc, opts...)
+}
+
+func (s *KeySet) keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	for _, k := range s.keys {
+		// Matching the algorithm too keeps a public key from being used as an
+		// HMAC secret
+		if k.ID == kid && k.method.Alg() == t.Method.Alg() {
+			return k.public, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public keys of the set as an RFC 7517 JSON Web Key Set.
+// Symmetric keys are left out.
+func (s *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for _, k := range s.keys {
+		if k.ID == "" {
+			continue
+		}
+		set.Keys = append(set.Keys, k.jwk())
+	}
+
+	return set
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (k *Key) jwk() JSONWebKey {
+	jwk := JSONWebKey{Kid: k.ID, Use: "sig", Alg: k.method.Alg()}
+
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the key from its required members
+// in lexicographic order.
+func (k JSONWebKey) thumbprint() string {
+	var members any
+
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	}
+
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,237 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSAKey(t *testing.T) *Key {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func newEd25519Key(t *testing.T) *Key {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestSignAndParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		key  *Key
+		alg  string
+	}{
+		{name: "RS256", key: newRSAKey(t), alg: "RS256"},
+		{name: "EdDSA", key: newEd25519Key(t), alg: "EdDSA"},
+		{name: "HS256", key: NewHMACKey([]byte("secret")), alg: "HS256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := NewKeySet(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := testClaims()
+			signed, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got jwt.RegisteredClaims
+			token, err := ks.Parse(signed, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token.Method.Alg() != tt.alg {
+				t.Errorf("got alg %q; want %q", token.Method.Alg(), tt.alg)
+			}
+			if kid, _ := token.Header["kid"].(string); kid != tt.key.ID {
+				t.Errorf("got kid %q; want %q", kid, tt.key.ID)
+			}
+			if got.Subject != claims.Subject {
+				t.Errorf("got subject %q; want %q", got.Subject, claims.Subject)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	t.Parallel()
+
+	oldKey, newKey := newRSAKey(t), newEd25519Key(t)
+
+	before, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key signs while the old one still verifies
+	during, err := NewKeySet(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := during.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{oldToken, newToken} {
+		_, err = during.Parse(token, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Errorf("expected token to verify during rotation: %v", err)
+		}
+	}
+
+	if n := len(during.JWKS().Keys); n != 2 {
+		t.Errorf("got %d published keys; want 2", n)
+	}
+
+	after, err := NewKeySet(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = after.Parse(oldToken, &jwt.RegisteredClaims{})
+	if err == nil {
+		t.Error("expected token signed with a removed key to be rejected")
+	}
+
+	// Tokens signed with an unknown key of a known algorithm
+	other, err := NewKeySet(newEd25519Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = other.Parse(newToken, &jwt.RegisteredClaims{})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v; want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	t.Parallel()
+
+	key := newRSAKey(t)
+	ks, err := NewKeySet(key, NewHMACKey([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HS256 token keyed with the published RSA key id must not verify
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = key.ID
+	signed, err := forged.SignedString(x509.MarshalPKCS1PublicKey(key.public.(*rsa.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ks.Parse(signed, &jwt.RegisteredClaims{})
+	if err == nil {
+		t.Error("expected forged token to be rejected")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, edKey := newRSAKey(t), newEd25519Key(t)
+	ks, err := NewKeySet(rsaKey, edKey, NewHMACKey([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := ks.JWKS().Keys
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys; want 2", len(keys))
+	}
+
+	if keys[0].Kty != "RSA" || keys[0].Alg != "RS256" || keys[0].Kid != rsaKey.ID || keys[0].E != "AQAB" {
+		t.Errorf("unexpected RSA key %+v", keys[0])
+	}
+	if keys[1].Kty != "OKP" || keys[1].Crv != "Ed25519" || keys[1].Alg != "EdDSA" || keys[1].Kid != edKey.ID {
+		t.Errorf("unexpected Ed25519 key %+v", keys[1])
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	t.Parallel()
+
+	// Example from RFC 7638, section 3.1
+	key := JSONWebKey{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3" +
+			"oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZH" +
+			"zu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8aw" +
+			"apJzKnqDKgw",
+	}
+
+	want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	if got := key.thumbprint(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestParsePEMRejectsWeakRSA(t *testing.T) {
+	t.Parallel()
+
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err == nil {
+		t.Error("expected a 1024 bit key to be rejected")
+	}
+}
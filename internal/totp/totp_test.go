@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed "12345678901234567890" of the RFC 6238 test vectors.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	// Test vectors from RFC 6238, appendix B
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{time: 59, want: "94287082"},
+		{time: 1111111109, want: "07081804"},
+		{time: 1111111111, want: "14050471"},
+		{time: 1234567890, want: "89005924"},
+		{time: 2000000000, want: "69279037"},
+		{time: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		tm := time.Unix(tt.time, 0)
+
+		if got := hotp([]byte("12345678901234567890"), uint64(Step(tm)), 8); got != tt.want {
+			t.Errorf("hotp at %d: got %q; want %q", tt.time, got, tt.want)
+		}
+
+		got, err := Code(rfcSecret, tm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.want[2:]; got != want {
+			t.Errorf("Code at %d: got %q; want %q", tt.time, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOk   bool
+	}{
+		{name: "Current step", code: "050471", skew: 1, wantStep: Step(now), wantOk: true},
+		{name: "Previous step", code: "081804", skew: 1, wantStep: Step(now) - 1, wantOk: true},
+		{name: "Outside skew", code: "081804", skew: 0},
+		{name: "Wrong code", code: "123456", skew: 1},
+		{name: "Wrong length", code: "14050471", skew: 1},
+		{name: "Empty", code: "", skew: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Errorf("got (%d, %t); want (%d, %t)", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	t.Parallel()
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("got %d byte secret; want %d", len(key), secretSize)
+	}
+
+	other, _ := GenerateSecret()
+	if other == secret {
+		t.Error("expected secrets to differ")
+	}
+}
+
+func TestURI(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse(URI("Materix", "jane@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Materix:jane@example.com" {
+		t.Errorf("unexpected uri %q", u)
+	}
+
+	q := u.Query()
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Materix" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+}